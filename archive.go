@@ -0,0 +1,237 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrArchivePath = errors.New("unsafe path in the archive")
+var ErrArchiveEntry = errors.New("unsupported entry in the archive")
+
+// WriteArchive writes the content of a directory to dst as a tar archive.
+// Only the names, the permissions, the modification times and the symbolic
+// links are kept: owners and access times are left out. Other special files
+// are skipped and reported through the log function.
+func WriteArchive(dst io.Writer, dir string, log func(string)) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(dst)
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(rel),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime().Truncate(time.Second),
+		}
+		switch {
+		case info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case info.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+		case (info.Mode() & os.ModeSymlink) != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			if hdr.Linkname, err = os.Readlink(name); err != nil {
+				return err
+			}
+		default:
+			log(fmt.Sprintf("`%s` is not a regular file, a directory or a symbolic link, skipped", name))
+			return nil
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return copyFile(tw, name, hdr.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func copyFile(dst io.Writer, name string, size int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = io.CopyN(dst, f, size); err != nil {
+		return fmt.Errorf("%s: %s (modified while archived?)", name, err)
+	}
+	return nil
+}
+
+// ExtractArchive extracts a tar archive written by WriteArchive into an empty
+// directory, then reads src until EOF. Existing files are never overwritten
+// and entries may not leave the directory: absolute and ".." paths are
+// rejected, no entry is written through a symbolic link and the symbolic links
+// that may point outside of the directory are skipped and reported through the
+// log function. Files are synced before being closed.
+func ExtractArchive(src io.Reader, dir string, log func(string)) error {
+	type dirMeta struct {
+		name  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var dirs []dirMeta
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, err := archivePath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.Mkdir(name, 0700)
+			// The permissions are set at the end, they may forbid writing
+			dirs = append(dirs, dirMeta{name, mode, hdr.ModTime})
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractFile(tr, name, mode, hdr.ModTime)
+		case tar.TypeSymlink:
+			if symlinkEscapes(dir, name, hdr.Linkname) {
+				log(fmt.Sprintf("symbolic link `%s` -> `%s` points outside of `%s`, skipped", name, hdr.Linkname, dir))
+			} else {
+				err = os.Symlink(hdr.Linkname, name)
+			}
+		default:
+			err = fmt.Errorf("%s: %s", hdr.Name, ErrArchiveEntry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].name, dirs[i].mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dirs[i].name, dirs[i].mtime, dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(ioutil.Discard, src)
+	return err
+}
+
+// archivePath returns the name of an archive entry in dir. It fails if the
+// entry would leave dir or be written through a symbolic link.
+func archivePath(dir, name string) (string, error) {
+	clean := path.Clean(strings.TrimSuffix(name, "/"))
+	if path.IsAbs(clean) || (clean == ".") || (clean == "..") || strings.HasPrefix(clean, "../") ||
+		strings.Contains(clean, "\\") {
+		return "", fmt.Errorf("%s: %s", name, ErrArchivePath)
+	}
+	parent := dir
+	parts := strings.Split(clean, "/")
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%s: %s", name, ErrArchivePath)
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// symlinkEscapes tells if a symbolic link created at name may point outside of
+// dir. The target must be relative and ".." is only allowed at its beginning,
+// since "link/.." is not the directory holding link when link is itself a
+// symbolic link.
+func symlinkEscapes(dir, name, target string) bool {
+	target = filepath.ToSlash(target)
+	if path.IsAbs(target) || filepath.IsAbs(target) {
+		return true
+	}
+	rel, err := filepath.Rel(dir, filepath.Dir(name))
+	if err != nil {
+		return true
+	}
+	depth := 0
+	if rel != "." {
+		depth = len(strings.Split(filepath.ToSlash(rel), "/"))
+	}
+	up, down := 0, false
+	for _, part := range strings.Split(target, "/") {
+		switch {
+		case (part == "") || (part == "."):
+		case part == "..":
+			if down {
+				return true
+			}
+			up++
+		default:
+			down = true
+		}
+	}
+	return up > depth
+}
+
+func extractFile(src io.Reader, name string, mode os.FileMode, mtime time.Time) error {
+	// O_EXCL never follows a symbolic link
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, src); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(name, mode)
+	}
+	if err == nil {
+		err = os.Chtimes(name, mtime, mtime)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+}
+
+// maliciousArchives are tar streams trying to write outside of the
+// extraction directory.
+var maliciousArchives = []struct {
+	test    string
+	entries []archiveEntry
+}{
+	{"parent", []archiveEntry{{"../x", tar.TypeReg, ""}}},
+	{"hidden parent", []archiveEntry{{"a/", tar.TypeDir, ""}, {"a/../../x", tar.TypeReg, ""}}},
+	{"absolute", []archiveEntry{{"/tmp/x", tar.TypeReg, ""}}},
+	{"symlink parent", []archiveEntry{{"s", tar.TypeSymlink, "."}, {"s/s/f", tar.TypeReg, ""}}},
+	{"file through symlink", []archiveEntry{{"s", tar.TypeSymlink, "f"}, {"s", tar.TypeReg, ""}}},
+	{"hard link", []archiveEntry{{"h", tar.TypeLink, "../x"}}},
+}
+
+func writeTar(t *testing.T, entries []archiveEntry) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0600}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = 4
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte("evil")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// outside returns the files of root that are not in dir.
+func outside(t *testing.T, root, dir string) []string {
+	var ret []string
+	filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (name != root) && (name != dir) && !strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			ret = append(ret, name)
+		}
+		return nil
+	})
+	return ret
+}
+
+func TestExtractArchiveRejects(t *testing.T) {
+	for _, c := range maliciousArchives {
+		root := t.TempDir()
+		dir := filepath.Join(root, "dir")
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		err := ExtractArchive(bytes.NewReader(writeTar(t, c.entries)), dir, func(string) {})
+		if err == nil {
+			t.Errorf("%s: extracted", c.test)
+		}
+		if files := outside(t, root, dir); len(files) > 0 {
+			t.Errorf("%s: written outside of the directory: %v", c.test, files)
+		}
+	}
+}
+
+func TestExtractArchiveSkipsEscapingSymlinks(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dir")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	entries := []archiveEntry{
+		{"a/", tar.TypeDir, ""},
+		{"a/up", tar.TypeSymlink, "../.."},
+		{"a/abs", tar.TypeSymlink, "/etc"},
+		{"a/in", tar.TypeSymlink, "../f"},
+		{"f", tar.TypeReg, ""},
+	}
+	var skipped int
+	err := ExtractArchive(bytes.NewReader(writeTar(t, entries)), dir, func(string) { skipped++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 2 {
+		t.Errorf("%d symbolic links skipped instead of 2", skipped)
+	}
+	if _, err = os.Lstat(filepath.Join(dir, "a", "in")); err != nil {
+		t.Error(err)
+	}
+	if files := outside(t, root, dir); len(files) > 0 {
+		t.Errorf("written outside of the directory: %v", files)
+	}
+}
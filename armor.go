@@ -0,0 +1,208 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// The ASCII armor is a text encoding of a ciphertext for mail and chat:
+//
+//	-----BEGIN CRYPT0 MESSAGE-----
+//	base64 of the ciphertext, 64 columns per line
+//	=CRC-24 of the ciphertext in base64 (as in OpenPGP)
+//	-----END CRYPT0 MESSAGE-----
+//
+// It is a transport encoding only: the armored bytes are the very ciphertext,
+// still authenticated by its HMAC.
+const ArmorBegin string = "-----BEGIN CRYPT0 MESSAGE-----"
+const ArmorEnd string = "-----END CRYPT0 MESSAGE-----"
+const ArmorExt string = ".asc"
+const ArmorColumns int = 64
+
+var ErrArmor = errors.New("malformed ASCII armor")
+var ErrArmorChecksum = errors.New("ASCII armor checksum mismatch, the message was damaged in transit")
+
+const crc24Init uint32 = 0xb704ce
+const crc24Poly uint32 = 0x1864cfb
+
+func crc24(crc uint32, p []byte) uint32 {
+	for _, b := range p {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if (crc & 0x1000000) != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+func crc24Line(crc uint32) string {
+	return "=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)})
+}
+
+// lineWriter wraps the base64 text at ArmorColumns.
+type lineWriter struct {
+	dst io.Writer
+	col int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	var buff bytes.Buffer
+	for left := p; len(left) > 0; {
+		todo := ArmorColumns - w.col
+		if todo > len(left) {
+			todo = len(left)
+		}
+		buff.Write(left[:todo])
+		left = left[todo:]
+		w.col += todo
+		if w.col == ArmorColumns {
+			buff.WriteByte('\n')
+			w.col = 0
+		}
+	}
+	if _, err := w.dst.Write(buff.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type armorWriter struct {
+	lines *lineWriter
+	enc   io.WriteCloser
+	crc   uint32
+}
+
+// NewArmorWriter returns a writer armoring what is written to it into dst.
+// The armor is only complete once the writer is closed, closing it does not
+// close dst.
+func NewArmorWriter(dst io.Writer) (io.WriteCloser, error) {
+	if _, err := io.WriteString(dst, ArmorBegin+"\n"); err != nil {
+		return nil, err
+	}
+	lines := &lineWriter{dst: dst}
+	return &armorWriter{lines, base64.NewEncoder(base64.StdEncoding, lines), crc24Init}, nil
+}
+
+func (w *armorWriter) Write(p []byte) (int, error) {
+	w.crc = crc24(w.crc, p)
+	return w.enc.Write(p)
+}
+
+func (w *armorWriter) Close() error {
+	if err := w.enc.Close(); err != nil {
+		return err
+	}
+	tail := crc24Line(w.crc) + "\n" + ArmorEnd + "\n"
+	if w.lines.col != 0 {
+		tail = "\n" + tail
+	}
+	_, err := io.WriteString(w.lines.dst, tail)
+	return err
+}
+
+// Armored tells if an ASCII armor begins in the first BufferSize bytes of src,
+// nothing is consumed.
+func Armored(src *bufio.Reader) bool {
+	head, _ := src.Peek(int(BufferSize))
+	return bytes.Contains(head, []byte(ArmorBegin))
+}
+
+type armorReader struct {
+	src     *bufio.Reader
+	started bool
+	pending string // base64 text not decoded yet
+	buff    []byte // decoded bytes not read yet
+	crc     uint32
+	sum     string
+	err     error
+}
+
+// NewArmorReader returns a reader decoding the first ASCII armor found in src.
+// The text around the armor, the email quoting ("> ") and the white spaces are
+// ignored, so are the line lengths. A damaged checksum is reported with
+// ErrArmorChecksum once the whole armor is read.
+func NewArmorReader(src io.Reader) io.Reader {
+	return &armorReader{src: bufio.NewReaderSize(src, int(BufferSize)), crc: crc24Init}
+}
+
+// armorLine removes the email quoting and the white spaces of a line.
+func armorLine(line string) string {
+	line = strings.TrimLeft(line, "> \t")
+	return strings.Join(strings.Fields(line), "")
+}
+
+func (r *armorReader) Read(p []byte) (int, error) {
+	for (len(r.buff) == 0) && (r.err == nil) {
+		r.err = r.next()
+	}
+	if len(r.buff) > 0 {
+		n := copy(p, r.buff)
+		r.buff = r.buff[n:]
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// next decodes the next line of the armor.
+func (r *armorReader) next() error {
+	raw, err := r.src.ReadString('\n')
+	if (err != nil) && ((err != io.EOF) || (raw == "")) {
+		if err == io.EOF {
+			return ErrArmor
+		}
+		return err
+	}
+	line := armorLine(raw)
+	switch {
+	case !r.started:
+		r.started = line == armorLine(ArmorBegin)
+	case line == armorLine(ArmorEnd):
+		return r.finish()
+	case strings.HasPrefix(line, "=") && (len(line) == 5):
+		r.sum = line
+	case strings.Contains(line, ":"):
+		// Armor headers are not used but tolerated
+	default:
+		r.pending += line
+		n := len(r.pending) - (len(r.pending) % 4)
+		if r.buff, err = base64.StdEncoding.DecodeString(r.pending[:n]); err != nil {
+			return ErrArmor
+		}
+		r.pending = r.pending[n:]
+		r.crc = crc24(r.crc, r.buff)
+	}
+	return nil
+}
+
+func (r *armorReader) finish() error {
+	if r.pending != "" {
+		return ErrArmor
+	}
+	if (r.sum != "") && (r.sum != crc24Line(r.crc)) {
+		return ErrArmorChecksum
+	}
+	return io.EOF
+}
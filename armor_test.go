@@ -0,0 +1,111 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// armor returns the ASCII armor of data.
+func armor(t *testing.T, data []byte) string {
+	var out bytes.Buffer
+	w, err := NewArmorWriter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// TestCRC24 checks the checksum against the check value of CRC-24/OPENPGP.
+func TestCRC24(t *testing.T) {
+	if crc := crc24(crc24Init, []byte("123456789")); crc != 0x21cf02 {
+		t.Fatalf("CRC-24 %06x instead of 21cf02", crc)
+	}
+}
+
+// TestArmorRoundTrip checks that armored data of any length is decoded back,
+// also when surrounded by text.
+func TestArmorRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 47, 48, 49, 1000, int(BufferSize) + 5} {
+		data := random(t, size)
+		text := armor(t, data)
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+			if len(line) > ArmorColumns {
+				t.Fatalf("%d bytes: line of %d columns", size, len(line))
+			}
+		}
+		got, err := ioutil.ReadAll(NewArmorReader(strings.NewReader("Hello,\n\n" + text + "\nBye\n")))
+		if err != nil {
+			t.Fatalf("%d bytes: %s", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%d bytes: round trip mismatch", size)
+		}
+	}
+}
+
+// TestArmorQuoted checks that an armor quoted in a reply is decoded.
+func TestArmorQuoted(t *testing.T) {
+	data := random(t, 500)
+	quoted := "On Monday, Alice wrote:\n> " + strings.Replace(strings.TrimSuffix(armor(t, data), "\n"), "\n", "\n> ", -1) + "\n"
+	got, err := ioutil.ReadAll(NewArmorReader(strings.NewReader(quoted)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("quoted armor mismatch")
+	}
+}
+
+// TestArmorChecksum checks that a damaged armor is rejected with
+// ErrArmorChecksum.
+func TestArmorChecksum(t *testing.T) {
+	data := random(t, 500)
+	text := armor(t, data)
+	lines := strings.Split(text, "\n")
+	// A bit of the data
+	body := []byte(lines[1])
+	if body[10] == 'A' {
+		body[10] = 'B'
+	} else {
+		body[10] = 'A'
+	}
+	damaged := append([]string{}, lines...)
+	damaged[1] = string(body)
+	if _, err := ioutil.ReadAll(NewArmorReader(strings.NewReader(strings.Join(damaged, "\n")))); err != ErrArmorChecksum {
+		t.Errorf("damaged data: %v", err)
+	}
+	// The checksum itself
+	for i, line := range lines {
+		if strings.HasPrefix(line, "=") {
+			damaged = append([]string{}, lines...)
+			damaged[i] = crc24Line(crc24(crc24Init, data) ^ 1)
+		}
+	}
+	if _, err := ioutil.ReadAll(NewArmorReader(strings.NewReader(strings.Join(damaged, "\n")))); err != ErrArmorChecksum {
+		t.Errorf("damaged checksum: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+)
+
+// A bundle holds one ciphertext of the same message per recipient:
+//
+//	magic (6 bytes) | FormatBundle (1 byte) | flags, 0 (1 byte) |
+//	number of sections (8 bytes) | size of a section (8 bytes) | sections
+//
+// Every section is a complete ciphertext made with the pad of one recipient.
+// The sections have the same size and are shuffled, so the bundle reveals the
+// number of recipients only. The bundle header is not authenticated: altering
+// it misaligns the sections, which then fail to authenticate.
+const FormatBundle byte = 0x80
+const BundleHeaderSize int = 24 // len(magic) + len(version) + len(flags) + len(count) + len(section size) = 6 + 1 + 1 + 8 + 8
+
+var ErrBundle = errors.New("the ciphertext is a multi-recipient bundle")
+
+// Bundle is the header of a multi-recipient bundle.
+type Bundle struct {
+	Count       int64
+	SectionSize int64
+}
+
+// WriteBundleHeader writes the header of a bundle to dst, the sections must
+// follow.
+func WriteBundleHeader(dst io.Writer, b *Bundle) error {
+	buff := make([]byte, BundleHeaderSize)
+	copy(buff, Magic)
+	buff[6] = FormatBundle
+	binary.BigEndian.PutUint64(buff[8:], uint64(b.Count))
+	binary.BigEndian.PutUint64(buff[16:], uint64(b.SectionSize))
+	_, err := dst.Write(buff)
+	return err
+}
+
+// ParseBundle reads the header of a bundle, the size of the whole ciphertext
+// is checked unless negative. It returns nil and no error if the ciphertext is
+// not a bundle.
+func ParseBundle(src io.Reader, size int64) (*Bundle, error) {
+	buff := make([]byte, BundleHeaderSize)
+	if _, err := io.ReadFull(src, buff); (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if (string(buff[:len(Magic)]) != Magic) || (buff[6] != FormatBundle) {
+		return nil, nil
+	}
+	b := &Bundle{int64(binary.BigEndian.Uint64(buff[8:])), int64(binary.BigEndian.Uint64(buff[16:]))}
+	if (b.Count < 1) || (b.SectionSize < 1) {
+		return nil, ErrMalformed
+	}
+	// Divided rather than multiplied, a forged count could overflow
+	body := size - int64(BundleHeaderSize)
+	if (size >= 0) && (((body % b.SectionSize) != 0) || ((body / b.SectionSize) != b.Count)) {
+		return nil, ErrMalformed
+	}
+	return b, nil
+}
+
+// Section skips the bytes of src (positioned after the bundle header, as left
+// by ParseBundle) up to the given section and returns a reader of this
+// section.
+func (b *Bundle) Section(src io.Reader, i int64) (io.Reader, error) {
+	if _, err := io.CopyN(ioutil.Discard, src, i*b.SectionSize); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(src, b.SectionSize), nil
+}
+
+// BundleOrder returns a random permutation of 0 to n-1, the order in which
+// the sections of the recipients are written.
+func BundleOrder(n int) ([]int, error) {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		order[i], order[j.Int64()] = order[j.Int64()], order[i]
+	}
+	return order, nil
+}
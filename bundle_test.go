@@ -0,0 +1,98 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// testBundle returns a bundle of count sections of size bytes, section i
+// filled with the byte i.
+func testBundle(t *testing.T, count, size int64) []byte {
+	var out bytes.Buffer
+	if err := WriteBundleHeader(&out, &Bundle{count, size}); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < count; i++ {
+		out.Write(bytes.Repeat([]byte{byte(i)}, int(size)))
+	}
+	return out.Bytes()
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	data := testBundle(t, 3, 100)
+	for i := int64(0); i < 3; i++ {
+		src := bytes.NewReader(data)
+		b, err := ParseBundle(src, int64(len(data)))
+		if (err != nil) || (b == nil) || (b.Count != 3) || (b.SectionSize != 100) {
+			t.Fatalf("%+v, %v", b, err)
+		}
+		section, err := b.Section(src, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(section)
+		if (err != nil) || !bytes.Equal(got, bytes.Repeat([]byte{byte(i)}, 100)) {
+			t.Fatalf("section %d: %v", i, err)
+		}
+	}
+}
+
+// TestBundleSize checks that a bundle is rejected unless its size is exactly
+// that of its sections, even when the count overflows.
+func TestBundleSize(t *testing.T) {
+	data := testBundle(t, 3, 100)
+	for _, size := range []int64{int64(len(data)) - 1, int64(len(data)) + 1, int64(BundleHeaderSize)} {
+		if _, err := ParseBundle(bytes.NewReader(data), size); err != ErrMalformed {
+			t.Fatalf("size %d: %v", size, err)
+		}
+	}
+	for _, h := range []*Bundle{{0, 100}, {3, 0}, {-1, 100}, {1 << 62, 4}} {
+		var out bytes.Buffer
+		WriteBundleHeader(&out, h)
+		if _, err := ParseBundle(&out, int64(BundleHeaderSize)); err != ErrMalformed {
+			t.Fatalf("%+v: %v", h, err)
+		}
+	}
+}
+
+// TestBundleTruncated checks that a truncated bundle is not taken for a bundle
+// or is rejected, and that a truncated section is short.
+func TestBundleTruncated(t *testing.T) {
+	data := testBundle(t, 2, 100)
+	b, err := ParseBundle(bytes.NewReader(data[:BundleHeaderSize-1]), -1)
+	if (b != nil) || (err != nil) {
+		t.Fatalf("truncated header: %+v, %v", b, err)
+	}
+	truncated := data[:len(data)-10]
+	if _, err = ParseBundle(bytes.NewReader(truncated), int64(len(truncated))); err != ErrMalformed {
+		t.Fatalf("truncated sections: %v", err)
+	}
+	src := bytes.NewReader(truncated)
+	if b, err = ParseBundle(src, -1); err != nil {
+		t.Fatal(err)
+	}
+	section, err := b.Section(src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadAll(section); len(got) != 90 {
+		t.Fatalf("%d bytes read from a truncated section", len(got))
+	}
+}
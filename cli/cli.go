@@ -0,0 +1,171 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+// Package cli holds what the crypt0 commands share: the command dispatch, the
+// messages, the exit codes and the configuration.
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes of every command.
+const ExitSuccess int = 0
+const ExitPad int = 1 // pad too short, no valid pad or running low
+const ExitError int = 9
+
+// Command is a crypt0 subcommand. Its alias, if any, is the name of the former
+// standalone binary, it runs the command when crypt0 is invoked under it.
+type Command struct {
+	Name  string
+	Alias string
+	Help  string
+	Main  func(args []string)
+}
+
+// Name is the command as invoked, such as "crypt0 encrypt" or "encrypt0". It
+// prefixes every message.
+var Name string = "crypt0"
+
+// Conf is the configuration, loaded by Run.
+var Conf *Config = nil
+
+var cleanups []func(status int)
+
+// AtExit registers a function that Exit calls with the exit status, the last
+// registered is called first.
+func AtExit(f func(status int)) {
+	cleanups = append(cleanups, f)
+}
+
+// Exit runs the functions registered with AtExit and exits.
+func Exit(status int) {
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i](status)
+	}
+	os.Exit(status)
+}
+
+func FatalCheck(err error) {
+	if err != nil {
+		FatalError(err.Error())
+	}
+}
+
+func FatalError(err string) {
+	Error(err)
+	Exit(ExitError)
+}
+
+// Error prints an error message without exiting.
+func Error(msg string) {
+	fmt.Fprintf(os.Stderr, "%s: error: %s\n", Name, msg)
+}
+
+func Warning(msg string) {
+	fmt.Fprintf(os.Stderr, "%s: warning: %s\n", Name, msg)
+}
+
+// Report prints a progress message on the standard output.
+func Report(msg string) {
+	fmt.Printf("%s: %s\n", Name, msg)
+}
+
+// Literal returns a file name given after "--": "-" then names a file in the
+// current directory, not the standard stream.
+func Literal(name string) string {
+	if name == "-" {
+		return "." + string(filepath.Separator) + name
+	}
+	return name
+}
+
+func Usage(commands []*Command) {
+	fmt.Fprintf(os.Stderr, "Usage:\n\n")
+	fmt.Fprintf(os.Stderr, "crypt0 command [arguments]\n\n")
+	for _, c := range commands {
+		if c.Alias == "" {
+			fmt.Fprintf(os.Stderr, "%-10s: %s\n", c.Name, c.Help)
+		} else {
+			fmt.Fprintf(os.Stderr, "%-10s: %s (also run as %s)\n", c.Name, c.Help, c.Alias)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "help      : print this help, `crypt0 command --help` prints the help of a command\n\n")
+	fmt.Fprintf(os.Stderr, "Configuration: %s\n\n", ConfigName())
+	fmt.Fprintf(os.Stderr, "Return values:\n\n")
+	fmt.Fprintf(os.Stderr, "0: success\n")
+	fmt.Fprintf(os.Stderr, "1: pad too short, no valid pad or pads running low\n")
+	fmt.Fprintf(os.Stderr, "9: other error\n")
+	Exit(ExitError)
+}
+
+// Run loads the configuration and runs the command named by the program name
+// (an alias) or by the first argument.
+func Run(commands []*Command) {
+	var err error
+	if Conf, err = LoadConfig(ConfigName()); err != nil {
+		FatalError(fmt.Sprintf("%s: %s", ConfigName(), err))
+	}
+	program := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	for _, c := range commands {
+		if (c.Alias != "") && (program == c.Alias) {
+			Name = c.Alias
+			c.Main(os.Args[1:])
+			Exit(ExitSuccess)
+		}
+	}
+	if len(os.Args) < 2 {
+		Usage(commands)
+	}
+	for _, c := range commands {
+		if os.Args[1] == c.Name {
+			Name = "crypt0 " + c.Name
+			c.Main(os.Args[2:])
+			Exit(ExitSuccess)
+		}
+	}
+	Usage(commands)
+}
+
+// Spawn runs a crypt0 command in a new process and returns its messages and
+// its exit status. Its standard input is stdin (nothing if nil) and its
+// standard output goes to stdout, or to the messages if nil.
+func Spawn(stdin io.Reader, stdout io.Writer, args ...string) (string, int) {
+	exe, err := os.Executable()
+	if err != nil {
+		return err.Error(), ExitError
+	}
+	var messages strings.Builder
+	// Named crypt0 whatever the name of the executable, not to run an alias
+	cmd := &exec.Cmd{Path: exe, Args: append([]string{"crypt0"}, args...), Stdin: stdin, Stdout: stdout,
+		Stderr: &messages}
+	if stdout == nil {
+		cmd.Stdout = &messages
+	}
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return messages.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		return err.Error(), ExitError
+	}
+	return messages.String(), ExitSuccess
+}
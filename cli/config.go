@@ -0,0 +1,142 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/piotrcki/crypt0"
+)
+
+// Config holds the defaults of the commands, read from the configuration
+// file of CRYPT0_HOME. Its lines are of the form `key = value`, the empty ones
+// and those starting with # are ignored:
+//
+//	wipe        = no, yes or dry-run, overridden by $CRYPT0_WIPE
+//	armor       = yes or no, the default of encrypt --armor
+//	slice       = yes or no, the default of encrypt --slice
+//	short       = yes or no, the default of encrypt --short
+//	cstrng      = the CSTRNG of genpads, overridden by $CSTRNG
+//	prng        = the PRNG of genpads, overridden by $PRNG
+//	min-pads    = the default of pads inventory --min-pads
+//	min-bytes   = the default of pads inventory --min-bytes
+//	min-message = the default of pads inventory --min-message
+type Config struct {
+	Wipe       string
+	Armor      bool
+	Slice      bool
+	Short      bool
+	CSTRNG     string
+	PRNG       string
+	Thresholds crypt0.Thresholds
+}
+
+// ConfigName returns the name of the configuration file of CRYPT0_HOME.
+func ConfigName() string {
+	return filepath.Join(crypt0.Home(), "config")
+}
+
+// LoadConfig reads a configuration file, a missing file gives the defaults.
+// The environment variables override the file.
+func LoadConfig(name string) (*Config, error) {
+	c := &Config{Wipe: crypt0.WipeNo}
+	data, err := ioutil.ReadFile(name)
+	if (err != nil) && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: `key = value` expected", n)
+		}
+		if err = c.set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if os.Getenv("CRYPT0_WIPE") != "" {
+		c.Wipe = crypt0.DefaultWipe()
+	}
+	if os.Getenv("CSTRNG") != "" {
+		c.CSTRNG = os.Getenv("CSTRNG")
+	}
+	if os.Getenv("PRNG") != "" {
+		c.PRNG = os.Getenv("PRNG")
+	}
+	return c, nil
+}
+
+func (c *Config) set(key, value string) error {
+	var err error
+	flag := func() bool {
+		switch value {
+		case "yes", "true", "1":
+			return true
+		case "no", "false", "0":
+			return false
+		}
+		err = fmt.Errorf("%s: yes or no expected", key)
+		return false
+	}
+	number := func() int64 {
+		n, nerr := strconv.ParseInt(value, 10, 64)
+		if (nerr != nil) || (n < 0) {
+			err = fmt.Errorf("%s: positive number expected", key)
+		}
+		return n
+	}
+	switch key {
+	case "wipe":
+		if (value != crypt0.WipeNo) && (value != crypt0.WipeYes) && (value != crypt0.WipeDryRun) {
+			return fmt.Errorf("wipe: no, yes or dry-run expected")
+		}
+		c.Wipe = value
+	case "armor":
+		c.Armor = flag()
+	case "slice":
+		c.Slice = flag()
+	case "short":
+		c.Short = flag()
+	case "cstrng":
+		c.CSTRNG = value
+	case "prng":
+		c.PRNG = value
+	case "min-pads":
+		c.Thresholds.Pads = int(number())
+	case "min-bytes":
+		c.Thresholds.Bytes = number()
+	case "min-message":
+		c.Thresholds.Message = number()
+	default:
+		return fmt.Errorf("unknown key `%s`", key)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+// Package crypt0 implements the crypt0 ciphertext format: one-time pad
+// encryption hardened with AES-256 in CFB mode and authenticated with
+// HMAC-SHA512. The encrypt0 and decrypt0 commands are thin wrappers around it.
+//
+// A pad is consumed in the following order:
+//
+//	bytes 0 to 95   : HMAC key
+//	bytes 96 to 127 : AES key
+//	bytes 128 to end: one-time pad key stream
+package crypt0
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+)
+
+const HmacKeySize int = 96
+const AesKeySize int = 32
+const IvSize int = 16
+const HeadSize int = 16
+const MacSize int = 64
+const PadOverhead int64 = 144       // len(hmacKey) + len(AESKey) + len(head) = 96 + 32 + 16
+const CiphertextOverhead int64 = 96 // len(sha512) + len(head) + len(iv) = 64 + 16 + 16
+const BufferSize int64 = 1024 * 1024
+const WritePadExt string = ".w.pad"
+const ReadPadExt string = ".r.pad"
+const UsedPadExt string = ".x.pad"
+const CiphertextExt string = ".enc"
+
+var ErrPadTooShort = errors.New("the pad is too short")
+var ErrAuthentication = errors.New("authentication failed")
+var ErrMalformed = errors.New("authenticated but malformed")
+var ErrSize = errors.New("plaintext size does not match the announced size")
+
+// keys holds the material read from the beginning of a pad.
+type keys struct {
+	hmacKey []byte
+	aesKey  []byte
+}
+
+func readKeys(pad io.Reader) (*keys, error) {
+	k := &keys{make([]byte, HmacKeySize), make([]byte, AesKeySize)}
+	if _, err := io.ReadFull(pad, k.hmacKey); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(pad, k.aesKey); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *keys) mac() hash.Hash {
+	return hmac.New(sha512.New, k.hmacKey)
+}
+
+func (k *keys) block() cipher.Block {
+	// The key size is fixed, NewCipher cannot fail here
+	block, _ := aes.NewCipher(k.aesKey)
+	return block
+}
+
+// Padding returns the number of 0x00 bytes to append to a plaintext of the
+// given size so that the ciphertext consumes the whole pad.
+func Padding(padSize, size int64) (int64, error) {
+	padding := padSize - PadOverhead - size
+	if padding < 0 {
+		return 0, ErrPadTooShort
+	}
+	return padding, nil
+}
+
+// xorBytes xors src into dst, both must have the same length.
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,43 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package main
+
+import (
+	"github.com/piotrcki/crypt0/cli"
+	"github.com/piotrcki/crypt0/decrypt0"
+	"github.com/piotrcki/crypt0/encrypt0"
+	"github.com/piotrcki/crypt0/genpads0"
+	"github.com/piotrcki/crypt0/gui0"
+	"github.com/piotrcki/crypt0/pads0"
+	"github.com/piotrcki/crypt0/serve0"
+	"github.com/piotrcki/crypt0/tui0"
+)
+
+var Commands = []*cli.Command{
+	{Name: "encrypt", Alias: "encrypt0", Help: "encrypt a file, a directory or the standard input", Main: encrypt0.Main},
+	{Name: "decrypt", Alias: "decrypt0", Help: "authenticate and decrypt a ciphertext", Main: decrypt0.Main},
+	{Name: "genpads", Alias: "genpads0", Help: "generate pads", Main: genpads0.Main},
+	{Name: "pads", Alias: "pads0", Help: "manage the pads: recover, rebuild, query, inventory, verify, export, import, split, merge", Main: pads0.Main},
+	{Name: "encrypt-ui", Alias: "encrypt0-gui", Help: "encrypt a file through dialogs", Main: gui0.EncryptMain},
+	{Name: "decrypt-ui", Alias: "decrypt0-gui", Help: "decrypt a file through dialogs", Main: gui0.DecryptMain},
+	{Name: "serve", Help: "serve a web interface on localhost", Main: serve0.Main},
+	{Name: "tui", Help: "manage the peers and their pads in a terminal interface", Main: tui0.Main},
+}
+
+func main() {
+	cli.Run(Commands)
+}
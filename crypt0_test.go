@@ -0,0 +1,136 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// baselineEncrypt is the encryption of encrypt0 0.3.2, before the library:
+// the keys at the beginning of the pad, the IV, the encrypted size, the
+// plaintext, the 0x00 padding up to the end of the pad unless short, the HMAC.
+func baselineEncrypt(pad, plaintext, iv []byte, short bool) []byte {
+	mac := hmac.New(sha512.New, pad[0:96])
+	block, _ := aes.NewCipher(pad[96:128])
+	stream := cipher.NewCFBEncrypter(block, iv)
+	out := append([]byte{}, iv...)
+	head := make([]byte, 16)
+	size := len(plaintext)
+	for i := 15; i > 7; i-- {
+		head[i] = byte(size)
+		size >>= 8
+	}
+	body := append(head, plaintext...)
+	if !short {
+		body = append(body, make([]byte, len(pad)-128-len(body))...)
+	}
+	for i := range body {
+		body[i] ^= pad[128+i]
+	}
+	stream.XORKeyStream(body, body)
+	out = append(out, body...)
+	mac.Write(out)
+	return mac.Sum(out)
+}
+
+// baselineDecrypt is the decryption of decrypt0 0.3.2.
+func baselineDecrypt(pad, ciphertext []byte) ([]byte, error) {
+	mac := hmac.New(sha512.New, pad[0:96])
+	mac.Write(ciphertext[:len(ciphertext)-64])
+	if !hmac.Equal(mac.Sum(nil), ciphertext[len(ciphertext)-64:]) {
+		return nil, errors.New("HMAC mismatch")
+	}
+	block, _ := aes.NewCipher(pad[96:128])
+	stream := cipher.NewCFBDecrypter(block, ciphertext[:16])
+	body := append([]byte{}, ciphertext[16:len(ciphertext)-64]...)
+	stream.XORKeyStream(body, body)
+	for i := range body {
+		body[i] ^= pad[128+i]
+	}
+	var size int
+	for i := 8; i < 16; i++ {
+		size = (size << 8) | int(body[i])
+	}
+	if !bytes.Equal(body[:8], make([]byte, 8)) || (size > len(body)-16) {
+		return nil, errors.New("bad head")
+	}
+	return body[16 : 16+size], nil
+}
+
+func random(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestLegacyFormat checks that the legacy ciphertexts of the library are byte
+// for byte those of the baseline commands, and that both decrypt the
+// ciphertexts of the other.
+func TestLegacyFormat(t *testing.T) {
+	pad := random(t, 4096)
+	for _, size := range []int{0, 1, 100, 4096 - int(PadOverhead)} {
+		for _, short := range []bool{false, true} {
+			plaintext := random(t, size)
+			iv := random(t, IvSize)
+			var padding int64
+			if !short {
+				padding = int64(len(pad)) - PadOverhead - int64(size)
+			}
+			var out bytes.Buffer
+			enc, err := NewEncrypter(bytes.NewReader(pad), &out, &Options{Size: int64(size),
+				Padding: padding, Rand: bytes.NewReader(iv), Legacy: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = enc.Write(plaintext); err != nil {
+				t.Fatal(err)
+			}
+			if err = enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			baseline := baselineEncrypt(pad, plaintext, iv, short)
+			if !bytes.Equal(out.Bytes(), baseline) {
+				t.Fatalf("size %d, short %v: the legacy ciphertext differs from the baseline one", size, short)
+			}
+			decrypted, err := baselineDecrypt(pad, out.Bytes())
+			if (err != nil) || !bytes.Equal(decrypted, plaintext) {
+				t.Fatalf("size %d, short %v: the baseline cannot decrypt the library: %v", size, short, err)
+			}
+			if err = Verify(bytes.NewReader(pad), bytes.NewReader(baseline)); err != nil {
+				t.Fatalf("size %d, short %v: %v", size, short, err)
+			}
+			dec, err := NewDecrypter(bytes.NewReader(pad), bytes.NewReader(baseline))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err = ioutil.ReadAll(dec)
+			if (err != nil) || !bytes.Equal(decrypted, plaintext) {
+				t.Fatalf("size %d, short %v: the library cannot decrypt the baseline: %v", size, short, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+// Verify checks that src is a ciphertext authenticated by pad without
+// decrypting it. It returns ErrAuthentication if the pad does not match.
+// The pad must be positioned at the offset given by the header of src (see
+// ParseHeader and OpenOffset).
+func Verify(pad io.Reader, src io.Reader) error {
+	k, err := readKeys(pad)
+	if err != nil {
+		return err
+	}
+	headPad := make([]byte, 8)
+	if _, err = io.ReadFull(pad, headPad); err != nil {
+		return err
+	}
+	h, iv, err := readHeader(src)
+	if err != nil {
+		return err
+	}
+	if (h.PadID != nil) && !bytes.Equal(h.PadID, padID(k.hmacKey)) {
+		return ErrAuthentication
+	}
+	head := make([]byte, 8)
+	if _, err = io.ReadFull(src, head); err != nil {
+		return err
+	}
+	mac := k.mac()
+	mac.Write(h.marshal())
+	mac.Write(iv)
+	mac.Write(head)
+	// Doing a fast test: the first 8 bytes of the header are 0x00
+	cipher.NewCFBDecrypter(k.block(), iv).XORKeyStream(head, head)
+	if !bytes.Equal(head, headPad) {
+		return ErrAuthentication
+	}
+	// Else, go ahead and check the rest of the ciphertext
+	return checkMac(mac, src)
+}
+
+// checkMac feeds everything but the last MacSize bytes of src to mac and
+// compares the result with those last bytes.
+func checkMac(mac hash.Hash, src io.Reader) error {
+	buff := make([]byte, BufferSize+int64(MacSize))
+	held := 0
+	for {
+		n, err := src.Read(buff[held:])
+		held += n
+		if held > MacSize {
+			mac.Write(buff[:held-MacSize])
+			held = copy(buff, buff[held-MacSize:held])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if held < MacSize {
+		return io.ErrUnexpectedEOF
+	}
+	if !hmac.Equal(buff[:MacSize], mac.Sum(nil)) {
+		return ErrAuthentication
+	}
+	return nil
+}
+
+// Decrypter is an io.Reader returning the plaintext of a ciphertext.
+// The plaintext is authenticated only once Read has returned io.EOF: callers
+// that must never release unauthenticated data have to call Verify first.
+type Decrypter struct {
+	pad     io.Reader
+	src     io.Reader
+	header  *Header
+	mac     hash.Hash     // HMAC_SHA512
+	stream  cipher.Stream // AES256_CFB
+	size    int64
+	left    int64
+	padBuff []byte
+	err     error
+}
+
+// NewDecrypter reads the keys from pad, the header of src and decrypts the
+// plaintext size. Like for Verify, the pad must be positioned at the offset
+// given by the header.
+func NewDecrypter(pad io.Reader, src io.Reader) (*Decrypter, error) {
+	k, err := readKeys(pad)
+	if err != nil {
+		return nil, err
+	}
+	headPad := make([]byte, HeadSize)
+	if _, err = io.ReadFull(pad, headPad); err != nil {
+		return nil, err
+	}
+	h, iv, err := readHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	if (h.PadID != nil) && !bytes.Equal(h.PadID, padID(k.hmacKey)) {
+		return nil, ErrAuthentication
+	}
+	head := make([]byte, HeadSize)
+	if _, err = io.ReadFull(src, head); err != nil {
+		return nil, err
+	}
+	d := &Decrypter{pad: pad, src: src, header: h, mac: k.mac()}
+	d.mac.Write(h.marshal())
+	d.mac.Write(iv)
+	d.mac.Write(head)
+	d.stream = cipher.NewCFBDecrypter(k.block(), iv)
+	// Decrypting the header
+	d.stream.XORKeyStream(head, head)
+	xorBytes(head, headPad)
+	if !bytes.Equal(head[:8], make([]byte, 8)) {
+		return nil, ErrAuthentication
+	}
+	// Getting the plaintext size
+	d.size = int64(binary.BigEndian.Uint64(head[8:]))
+	if d.size < 0 {
+		return nil, ErrMalformed
+	}
+	d.left = d.size
+	return d, nil
+}
+
+// Header returns the container header of the ciphertext.
+func (d *Decrypter) Header() *Header {
+	return d.header
+}
+
+// Size returns the plaintext size announced by the header.
+func (d *Decrypter) Size() int64 {
+	return d.size
+}
+
+// Read decrypts the plaintext. After the last plaintext byte, the rest of the
+// ciphertext is read and authenticated: Read returns io.EOF on success and
+// ErrAuthentication if the HMAC does not match.
+func (d *Decrypter) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.left == 0 {
+		d.err = checkMac(d.mac, d.src)
+		if d.err == nil {
+			d.err = io.EOF
+		}
+		return 0, d.err
+	}
+	if int64(len(p)) > d.left {
+		p = p[:d.left]
+	}
+	if int64(len(p)) > BufferSize {
+		p = p[:BufferSize]
+	}
+	n, err := d.src.Read(p)
+	if n > 0 {
+		if cap(d.padBuff) < n {
+			d.padBuff = make([]byte, n)
+		}
+		padBuff := d.padBuff[:n]
+		if _, d.err = io.ReadFull(d.pad, padBuff); d.err != nil {
+			return 0, d.err
+		}
+		d.mac.Write(p[:n])
+		d.stream.XORKeyStream(p[:n], p[:n])
+		xorBytes(p[:n], padBuff)
+		d.left -= int64(n)
+	}
+	if err == io.EOF {
+		err = nil
+		if d.left > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	d.err = err
+	return n, err
+}
@@ -14,31 +14,28 @@
 //   along with this program; if not, write to the Free Software Foundation,
 //   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
 
-package main
+// Package decrypt0 is the `crypt0 decrypt` command, also run as decrypt0.
+package decrypt0
 
 import (
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/hmac"
-	"crypto/sha512"
+	"bufio"
 	"fmt"
-	"hash"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/piotrcki/crypt0"
+	"github.com/piotrcki/crypt0/cli"
 )
 
-const ExitSuccess int = 0
-const ExitNoValidPad int = 1
-const ExitError = 9
 const CiphertextExt string = ".enc"
 const PadExt string = ".r.pad"
-const PadOverhead int64 = 48        // 144 - 96
-const CiphertextOverhead int64 = 96 // len(sha512) + len(head) + len(iv) = 64 + 16 + 16
-const BufferSize int64 = 1024 * 1024
+const StdStream string = "-"
 
+var Args []string // the command line, Args[0] is the command name
 var Fplaintext *os.File = nil
 var Fciphertext *os.File = nil
 var Fpad *os.File = nil
@@ -48,75 +45,150 @@ var PadSize int64 = -1
 var PlaintextName string = ""
 var CiphertextName string = ""
 var PadName string = ""
+var OutputName string = ""
+var Wipe string = crypt0.WipeNo
+var Extracted bool = false // the plaintext directory was created
 
-var Hmac hash.Hash       // HMAC_SHA512
-var Cipher cipher.Stream // AES256_CFB
+var Header *crypt0.Header = nil
+var Bundle *crypt0.Bundle = nil
+var Section int64 = 0 // the section of the bundle being decrypted
+var Index *crypt0.Index = nil
+var Spool *crypt0.Spool = nil
+var Ciphertext io.Reader = nil // Fciphertext or Spool
+var Decrypter *crypt0.Decrypter
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage:\n\n")
-	fmt.Fprintf(os.Stderr, "decrypt0 ciphertext-file pad\n\n")
-	fmt.Fprintf(os.Stderr, "ciphertext-file: the file to decrypt (a .enc file)\n")
-	fmt.Fprintf(os.Stderr, "pad            : the pad (a .r.pad file) to use or a directory containing it\n\n")
+	fmt.Fprintf(os.Stderr, "%s [-o output] [--wipe|--no-wipe|--wipe-dry-run] [--] ciphertext-file pad\n\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "ciphertext-file: the file to decrypt (a .enc or an ASCII-armored .enc.asc file), or - to read the standard input\n")
+	fmt.Fprintf(os.Stderr, "pad            : the pad (a .r.pad file) to use or a directory containing it\n")
+	fmt.Fprintf(os.Stderr, "-o output      : the plaintext file (or directory), - for the standard output (the default with a - ciphertext-file)\n")
+	fmt.Fprintf(os.Stderr, "--wipe         : after the decryption, overwrite the slice of the pad used by the ciphertext with random data, an exhausted pad is removed\n")
+	fmt.Fprintf(os.Stderr, "--no-wipe      : do not wipe the pad (the default unless CRYPT0_WIPE or the configuration says otherwise)\n")
+	fmt.Fprintf(os.Stderr, "--wipe-dry-run : only report what --wipe would do\n")
+	fmt.Fprintf(os.Stderr, "--             : end of the options, the following arguments are files even if they start with -\n")
+	fmt.Fprintf(os.Stderr, "the options may also follow ciphertext-file and pad\n\n")
 	fmt.Fprintf(os.Stderr, "Return values:\n\n")
 	fmt.Fprintf(os.Stderr, "0: decryption success\n")
 	fmt.Fprintf(os.Stderr, "1: invalid pad or no valid pad in the directory\n")
 	fmt.Fprintf(os.Stderr, "9: other error\n")
-	CleanExit(ExitError)
-}
-
-func FatalCheck(err error) {
-	if err != nil {
-		FatalError(err.Error())
-	}
-}
-
-func FatalError(err string) {
-	fmt.Fprintf(os.Stderr, "decrypt0: error: %s\n", err)
-	CleanExit(ExitError)
+	cli.Exit(cli.ExitError)
 }
 
-func CleanExit(status int) {
+// Cleanup releases the resources, it is called by cli.Exit.
+func Cleanup(status int) {
 	if Fciphertext != nil {
 		Fciphertext.Close()
 	}
+	if Spool != nil {
+		Spool.Close()
+	}
 	if Fplaintext != nil {
 		Fplaintext.Close()
-		if status != ExitSuccess {
+		if (status != cli.ExitSuccess) && (Fplaintext != os.Stdout) {
 			os.Remove(PlaintextName)
 		}
 	}
+	if Extracted && (status != cli.ExitSuccess) {
+		os.RemoveAll(PlaintextName)
+	}
 	if Fpad != nil {
 		Fpad.Close()
 		// No rollback on the pad name here
 	}
-	os.Exit(status)
 }
 
 func ParseArgs() {
-	if len(os.Args) != 3 {
+	var args []string // the positional arguments, the options may come anywhere
+	literal := false  // after "--", every argument is positional
+	Wipe = cli.Conf.Wipe
+	for i := 1; i < len(Args); i++ {
+		switch {
+		case literal:
+			args = append(args, cli.Literal(Args[i]))
+		case Args[i] == "--":
+			literal = true
+		case (Args[i] == "-o") && (i+1 < len(Args)):
+			i++
+			OutputName = Args[i]
+		case Args[i] == "--wipe":
+			Wipe = crypt0.WipeYes
+		case Args[i] == "--no-wipe":
+			Wipe = crypt0.WipeNo
+		case Args[i] == "--wipe-dry-run":
+			Wipe = crypt0.WipeDryRun
+		case strings.HasPrefix(Args[i], "-") && (Args[i] != StdStream):
+			Usage()
+		default:
+			args = append(args, Args[i])
+		}
+	}
+	if len(args) != 2 {
 		Usage()
 	}
-	CiphertextName = os.Args[1]
-	PadName = os.Args[2]
-	indx := strings.Index(CiphertextName, CiphertextExt)
-	if (indx <= 0) || (indx != (len(CiphertextName) - len(CiphertextExt))) {
+	CiphertextName = args[0]
+	PadName = args[1]
+	if CiphertextName == StdStream {
+		if OutputName == "" {
+			OutputName = StdStream
+		}
+		return
+	}
+	name := strings.TrimSuffix(CiphertextName, crypt0.ArmorExt)
+	indx := strings.Index(name, CiphertextExt)
+	if (OutputName == "") && ((indx <= 0) || (indx != (len(name) - len(CiphertextExt)))) {
 		Usage()
 	}
 }
 
+// IsArmored tells if the ciphertext file is ASCII-armored.
+func IsArmored() bool {
+	input, err := os.Open(CiphertextName)
+	cli.FatalCheck(err)
+	defer input.Close()
+	return crypt0.Armored(bufio.NewReaderSize(input, int(crypt0.BufferSize)))
+}
+
+// SpoolInput copies the ciphertext to a private temporary file. This lets the
+// standard input be read twice and ensures that the bytes sent to the standard
+// output come from the very ciphertext that was authenticated. ASCII-armored
+// ciphertexts are decoded on the way.
+func SpoolInput() {
+	var err error
+	input := os.Stdin
+	if CiphertextName != StdStream {
+		input, err = os.Open(CiphertextName)
+		cli.FatalCheck(err)
+		defer input.Close()
+	}
+	src := bufio.NewReaderSize(input, int(crypt0.BufferSize))
+	var ciphertext io.Reader = src
+	if crypt0.Armored(src) {
+		ciphertext = crypt0.NewArmorReader(src)
+	}
+	Spool, err = crypt0.NewSpool(ciphertext, -1)
+	cli.FatalCheck(err)
+	CiphertextSize = Spool.Size()
+}
+
 func OpenFiles() {
 	var err error
 	if len(PadName) > 0 {
 		Fpad, err = os.Open(PadName)
-		FatalCheck(err)
+		cli.FatalCheck(err)
+		info, err := Fpad.Stat()
+		cli.FatalCheck(err)
+		PadSize = info.Size()
+		// The ciphertext may use a slice in the middle of the pad
+		_, err = Fpad.Seek(Header.Offset, 0)
+		cli.FatalCheck(err)
 	}
-	if len(CiphertextName) > 0 {
-		Fciphertext, err = os.Open(CiphertextName)
-		FatalCheck(err)
-	}
-	if len(PlaintextName) > 0 {
+	OpenCiphertext()
+	if PlaintextName == StdStream {
+		Fplaintext = os.Stdout
+	} else if (len(PlaintextName) > 0) && !IsArchive() {
 		Fplaintext, err = os.Create(PlaintextName)
-		FatalCheck(err)
+		cli.FatalCheck(err)
 	}
 }
 
@@ -124,77 +196,156 @@ func CheckIntegrity() bool {
 	OpenFiles()
 	defer Fciphertext.Close()
 	defer Fpad.Close()
-	// Reading HMAC key, AES key and header from the pad
-	hmacKey := make([]byte, 96)
-	_, err := io.ReadFull(Fpad, hmacKey)
-	FatalCheck(err)
-	aesKey := make([]byte, 32)
-	_, err = io.ReadFull(Fpad, aesKey)
-	FatalCheck(err)
-	headPad := make([]byte, 8)
-	_, err = io.ReadFull(Fpad, headPad)
-	FatalCheck(err)
-	Hmac = hmac.New(sha512.New, hmacKey)
-	AES, err := aes.NewCipher(aesKey)
-	FatalCheck(err)
-	// Reading IV and first encrypted 8 bytes from the ciphertext
-	iv := make([]byte, 16)
-	_, err = io.ReadFull(Fciphertext, iv)
-	FatalCheck(err)
-	head := make([]byte, 8)
-	_, err = io.ReadFull(Fciphertext, head)
-	FatalCheck(err)
-	// Doing a fast test
-	Hmac := hmac.New(sha512.New, hmacKey)
-	Hmac.Write(iv)
-	Hmac.Write(head)
-	Cipher = cipher.NewCFBDecrypter(AES, iv)
-	Cipher.XORKeyStream(head, head)
-	if !bytes.Equal(head, headPad) {
-		// If this fail, stop here
+	err := crypt0.Verify(Fpad, Ciphertext)
+	if err == crypt0.ErrAuthentication {
 		return false
 	}
-	// Else, go ahead and check the rest of the ciphertext
-	var toRead int64 = CiphertextSize - 64 - (8 + 16) // (8 + 16) => already read; 64 => len(hmac)
-	var blocks int64 = (toRead / BufferSize) + 1
-	var i int64
-	for i = 0; i < blocks; i++ {
-		todo := BufferSize
-		if i == (blocks - 1) {
-			todo = toRead % BufferSize
-		}
-		buff := make([]byte, todo)
-		_, err = io.ReadFull(Fciphertext, buff)
-		FatalCheck(err)
-		Hmac.Write(buff)
+	cli.FatalCheck(err)
+	return true
+}
+
+// OpenCiphertext opens the ciphertext, or the current section of a bundle.
+func OpenCiphertext() {
+	var err error
+	if Spool != nil {
+		cli.FatalCheck(Spool.Rewind())
+		Ciphertext = Spool
+	} else {
+		Fciphertext, err = os.Open(CiphertextName)
+		cli.FatalCheck(err)
+		Ciphertext = Fciphertext
 	}
-	hmac := make([]byte, 64)
-	_, err = io.ReadFull(Fciphertext, hmac)
-	if bytes.Equal(hmac, Hmac.Sum(nil)) {
-		return true
+	if Bundle != nil {
+		_, err = crypt0.ParseBundle(Ciphertext, -1)
+		cli.FatalCheck(err)
+		Ciphertext, err = Bundle.Section(Ciphertext, Section)
+		cli.FatalCheck(err)
 	}
-	return false
 }
 
-func FindPad() bool {
-	if CiphertextSize == -1 {
+func CloseCiphertext() {
+	if Fciphertext != nil {
+		Fciphertext.Close()
+		Fciphertext = nil
+	}
+}
+
+// ReadBundle gets the sections of a multi-recipient bundle. A ciphertext that
+// is not a bundle is its own single section.
+func ReadBundle() {
+	var err error
+	if Spool == nil {
 		inputInfo, err := os.Stat(CiphertextName)
-		FatalCheck(err)
+		cli.FatalCheck(err)
 		if inputInfo.Mode().IsRegular() == false {
-			FatalError(fmt.Sprintf("%s is not a regular file.", CiphertextName))
+			cli.FatalError(fmt.Sprintf("%s is not a regular file.", CiphertextName))
 		}
 		CiphertextSize = inputInfo.Size()
 	}
+	OpenCiphertext()
+	Bundle, err = crypt0.ParseBundle(Ciphertext, CiphertextSize)
+	CloseCiphertext()
+	cli.FatalCheck(err)
+	if Bundle != nil {
+		CiphertextSize = Bundle.SectionSize
+	}
+}
+
+// Sections returns the number of sections of the ciphertext.
+func Sections() int64 {
+	if Bundle == nil {
+		return 1
+	}
+	return Bundle.Count
+}
+
+// ReadHeader gets the format of the ciphertext (of the current section of a
+// bundle), legacy ciphertexts have no header.
+func ReadHeader() {
+	var err error
+	OpenCiphertext()
+	Header, err = crypt0.ParseHeader(Ciphertext)
+	CloseCiphertext()
+	cli.FatalCheck(err)
+}
+
+// FindIndexed looks the pad IDs of the ciphertext up in the pad index, so that
+// the pads do not have to be tried one by one.
+func FindIndexed() bool {
+	var err error
+	Index, err = crypt0.OpenIndex(crypt0.IndexName())
+	if err != nil {
+		cli.Warning(fmt.Sprintf("pad index ignored: %s", err))
+		return false
+	}
+	root, err := filepath.Abs(PadName)
+	cli.FatalCheck(err)
+	for Section = 0; Section < Sections(); Section++ {
+		ReadHeader()
+		if Header.PadID == nil {
+			continue
+		}
+		r, h := Index.Lookup(Header.PadID)
+		if (r == nil) || !Within(root, r.Path) {
+			continue
+		}
+		if _, err = os.Stat(r.Path); err != nil {
+			continue
+		}
+		oldPadName := PadName
+		PadName = r.Path
+		if OpenHeader() && (h.Offset == Header.Offset) && CheckIntegrity() {
+			return true
+		}
+		PadName = oldPadName
+	}
+	return false
+}
+
+// OpenHeader recovers the pad offset of the header with the offset key of the
+// pad. It tells if the pad may hold the slice of the ciphertext.
+func OpenHeader() bool {
+	pad, err := os.Open(PadName)
+	cli.FatalCheck(err)
+	defer pad.Close()
+	info, err := pad.Stat()
+	cli.FatalCheck(err)
+	if Header.Version != crypt0.FormatLegacy {
+		key, err := crypt0.ReadOffsetKey(pad, info.Size())
+		if err == crypt0.ErrPadTooShort {
+			return false
+		}
+		cli.FatalCheck(err)
+		if Header.OpenOffset(key) != nil {
+			return false
+		}
+	}
+	// The pad bytes of a ciphertext exceed its size by the keys that are not sent
+	return (info.Size() - Header.Offset - (CiphertextSize - Header.Len())) >= (crypt0.PadOverhead - crypt0.CiphertextOverhead)
+}
+
+// Within tells if a file is the given pad or is in the given directory.
+func Within(root, name string) bool {
+	return (name == root) || strings.HasPrefix(name, root+string(os.PathSeparator))
+}
+
+func FindPad() bool {
 	info, err := os.Stat(PadName)
-	FatalCheck(err)
-	if info.Mode().IsRegular() && ((info.Size() - CiphertextSize) >= PadOverhead) {
+	cli.FatalCheck(err)
+	if info.Mode().IsRegular() {
 		indx := strings.Index(PadName, PadExt)
-		if (indx > 0) && (indx == (len(PadName) - len(PadExt))) {
-			return CheckIntegrity()
+		if (indx <= 0) || (indx != (len(PadName) - len(PadExt))) {
+			return false
+		}
+		for Section = 0; Section < Sections(); Section++ {
+			ReadHeader()
+			if OpenHeader() && CheckIntegrity() {
+				return true
+			}
 		}
 	} else if info.Mode().IsDir() {
 		infos, err := ioutil.ReadDir(PadName)
-		FatalCheck(err)
+		cli.FatalCheck(err)
 		oldPadName := PadName
 		for _, f := range infos {
 			PadName = fmt.Sprintf("%s%c%s", oldPadName, os.PathSeparator, f.Name())
@@ -209,80 +360,147 @@ func FindPad() bool {
 }
 
 func DecryptInit() {
-	PlaintextName = strings.Replace(CiphertextName, ".enc", "", -1)
+	PlaintextName = OutputName
+	if PlaintextName == "" {
+		PlaintextName = strings.TrimSuffix(strings.TrimSuffix(CiphertextName, crypt0.ArmorExt), CiphertextExt)
+	}
 	OpenFiles()
-	// Reading HMAC key, AES key and header from the pad
-	hmacKey := make([]byte, 96)
-	_, err := io.ReadFull(Fpad, hmacKey)
-	FatalCheck(err)
-	aesKey := make([]byte, 32)
-	_, err = io.ReadFull(Fpad, aesKey)
-	FatalCheck(err)
-	headPad := make([]byte, 16)
-	_, err = io.ReadFull(Fpad, headPad)
-	FatalCheck(err)
-	Hmac = hmac.New(sha512.New, hmacKey)
-	AES, err := aes.NewCipher(aesKey)
-	FatalCheck(err)
-	// Reading IV and first encrypted 16 bytes from the ciphertext
-	iv := make([]byte, 16)
-	_, err = io.ReadFull(Fciphertext, iv)
-	FatalCheck(err)
-	head := make([]byte, 16)
-	_, err = io.ReadFull(Fciphertext, head)
-	FatalCheck(err)
-	Cipher = cipher.NewCFBDecrypter(AES, iv)
-	// Decrypting the header
-	Cipher.XORKeyStream(head, head)
-	for i := 0; i < 16; i++ {
-		head[i] ^= headPad[i]
-	}
-	// Getting the plaintext size
-	PlaintextSize = 0
-	for i := 8; i < 16; i++ {
-		PlaintextSize *= 256
-		PlaintextSize += int64(head[i])
-	}
-	if (PlaintextSize < 0) || ((CiphertextSize - PlaintextSize) < CiphertextOverhead) {
-		FatalError(fmt.Sprintf("%s is authenticated but malformed.", CiphertextName))
+	var err error
+	Decrypter, err = crypt0.NewDecrypter(Fpad, Ciphertext)
+	cli.FatalCheck(err)
+	PlaintextSize = Decrypter.Size()
+	if (CiphertextSize - Header.Len() - PlaintextSize) < crypt0.CiphertextOverhead {
+		cli.FatalError(fmt.Sprintf("%s is %s.", CiphertextName, crypt0.ErrMalformed))
 	}
 }
 
+// IsArchive tells if the plaintext is the tar archive of a directory.
+func IsArchive() bool {
+	return (Header.Flags & crypt0.FlagArchive) != 0
+}
+
 func Decrypt() {
-	// Decrypting the actual plaintext
-	var blocks int64 = (PlaintextSize / BufferSize) + 1
-	var i, j int64
-	for i = 0; i < blocks; i++ {
-		todo := BufferSize
-		if todo == 0 {
-			continue
+	if IsArchive() && (Fplaintext == nil) {
+		cli.FatalCheck(os.Mkdir(PlaintextName, 0700))
+		Extracted = true
+		cli.FatalCheck(crypt0.ExtractArchive(Decrypter, PlaintextName, cli.Warning))
+		return
+	}
+	_, err := io.Copy(Fplaintext, Decrypter)
+	cli.FatalCheck(err)
+	if Fplaintext != os.Stdout {
+		// The plaintext must be on the disk before the pad is wiped
+		cli.FatalCheck(Fplaintext.Sync())
+	}
+}
+
+// SliceRange returns the slice of the pad used by the ciphertext.
+func SliceRange() crypt0.Range {
+	return crypt0.Range{
+		Offset: Header.Offset,
+		Length: CiphertextSize - Header.Len() - crypt0.CiphertextOverhead + crypt0.PadOverhead,
+	}
+}
+
+// UpdateIndex replaces in the pad index the pad ID of the slice just used by
+// the one of the slice that follows it, the next one the sender uses.
+func UpdateIndex() {
+	if Index == nil {
+		return
+	}
+	path, err := filepath.Abs(PadName)
+	if err == nil {
+		if _, err = os.Stat(path); os.IsNotExist(err) {
+			// Destroyed
+			Index.Remove(path)
+			err = nil
+		} else if err == nil {
+			r := Index.Add(path)
+			r.Forget(Header.Offset)
+			if Header.Version != crypt0.FormatLegacy {
+				err = r.Expect(SliceRange().End())
+			}
 		}
-		if i == (blocks - 1) {
-			todo = PlaintextSize % BufferSize
+	}
+	if err == nil {
+		var r *crypt0.PadRecord
+		if r, err = Index.Update(path); r != nil {
+			r.LastUsed = time.Now().UTC()
 		}
-		buff := make([]byte, todo)
-		padBuff := make([]byte, todo)
-		_, err := io.ReadFull(Fciphertext, buff)
-		FatalCheck(err)
-		_, err = io.ReadFull(Fpad, padBuff)
-		FatalCheck(err)
-		Cipher.XORKeyStream(buff, buff)
-		for j = 0; j < todo; j++ {
-			buff[j] ^= padBuff[j]
+	}
+	if err == nil {
+		err = Index.Save()
+	}
+	if err != nil {
+		cli.Warning(fmt.Sprintf("pad index not updated: %s", err))
+	}
+}
+
+// WipePad destroys the slice of the pad used by the ciphertext so that it
+// cannot be decrypted again. Whole-pad ciphertexts (format 0) and the
+// last slice of a pad destroy the whole pad.
+func WipePad(report *os.File) {
+	if Wipe == crypt0.WipeNo {
+		return
+	}
+	Fpad.Close()
+	Fpad = nil
+	slice := SliceRange()
+	end := PadSize
+	if Header.Version != crypt0.FormatLegacy {
+		end = crypt0.Sliceable(PadSize)
+	}
+	whole := (Header.Version == crypt0.FormatLegacy) || ((end - slice.End()) < crypt0.PadOverhead)
+	if Wipe == crypt0.WipeDryRun {
+		if whole {
+			fmt.Fprintf(report, "%s: dry-run: `%s` would be wiped and removed.\n", cli.Name, PadName)
+		} else {
+			fmt.Fprintf(report, "%s: dry-run: bytes %d to %d of `%s` would be wiped.\n", cli.Name,
+				slice.Offset, slice.End()-1, PadName)
 		}
-		_, err = Fplaintext.Write(buff)
+		return
+	}
+	var err error
+	if whole {
+		err = crypt0.DestroyPad(PadName)
+	} else {
+		err = crypt0.WipeRange(PadName, slice)
+	}
+	if err != nil {
+		// The plaintext is valid, this is not a reason to fail
+		cli.Warning(fmt.Sprintf("`%s` not wiped: %s", PadName, err))
+	} else if whole {
+		fmt.Fprintf(report, "%s: `%s` wiped and removed.\n", cli.Name, PadName)
+	} else {
+		fmt.Fprintf(report, "%s: bytes %d to %d of `%s` wiped.\n", cli.Name, slice.Offset, slice.End()-1, PadName)
 	}
 }
 
-func main() {
+// Main runs the command with its arguments.
+func Main(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	cli.AtExit(Cleanup)
 	ParseArgs()
-	if !FindPad() {
-		fmt.Fprintf(os.Stderr, "decrypt0: error: failed to find valid pad for `%s`.\n", CiphertextName)
-		CleanExit(ExitNoValidPad)
+	if (CiphertextName == StdStream) || (OutputName == StdStream) || IsArmored() {
+		SpoolInput()
+	}
+	ReadBundle()
+	if !FindIndexed() && !FindPad() {
+		cli.Error(fmt.Sprintf("failed to find valid pad for `%s`.", CiphertextName))
+		cli.Exit(cli.ExitPad)
 	}
 	DecryptInit()
 	Decrypt()
-	fmt.Printf("decrypt0: success: `%s` successfully authenticated and decrypted using `%s`.",
+	report := os.Stdout
+	if Fplaintext == os.Stdout {
+		report = os.Stderr
+	}
+	fmt.Fprintf(report, "%s: success: `%s` successfully authenticated and decrypted using `%s`.", cli.Name,
 		CiphertextName, PadName)
-	CleanExit(ExitSuccess)
+	if Wipe != crypt0.WipeNo {
+		fmt.Fprintf(report, "\n")
+	}
+	WipePad(report)
+	UpdateIndex()
+	cli.Exit(cli.ExitSuccess)
 }
@@ -0,0 +1,189 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+var errClosed = errors.New("crypt0: write to a closed encrypter")
+var errOffset = errors.New("crypt0: negative pad offset")
+var errOffsetKey = errors.New("crypt0: no offset key")
+
+// Options describes the message to encrypt.
+type Options struct {
+	Size      int64     // size of the plaintext, it must be known in advance
+	Padding   int64     // number of 0x00 bytes appended to hide the plaintext size
+	Offset    int64     // position of the pad slice in the pad file, sealed in the header
+	OffsetKey []byte    // the offset key of the pad (see ReadOffsetKey), unless Legacy
+	Rand      io.Reader // source of the IV, crypto/rand is used when nil
+	Legacy    bool      // write a headerless 0.x ciphertext
+	Archive   bool      // the plaintext is a tar archive (see WriteArchive)
+}
+
+// Encrypter is an io.WriteCloser encrypting exactly Options.Size bytes of
+// plaintext. Close must be called to write the padding and the HMAC.
+type Encrypter struct {
+	pad     io.Reader
+	dst     io.Writer
+	mac     hash.Hash     // HMAC_SHA512
+	stream  cipher.Stream // AES256_CFB
+	left    int64
+	padding int64
+	padBuff []byte
+	err     error
+}
+
+// NewEncrypter reads the keys from pad and writes the header, the IV and the
+// encrypted plaintext size to dst. The pad must be positioned at
+// Options.Offset, whose slice must not include the offset key.
+func NewEncrypter(pad io.Reader, dst io.Writer, opts *Options) (*Encrypter, error) {
+	if (opts.Size < 0) || (opts.Padding < 0) {
+		return nil, ErrSize
+	}
+	if opts.Offset < 0 {
+		return nil, errOffset
+	}
+	if !opts.Legacy && (len(opts.OffsetKey) != int(OffsetKeySize)) {
+		return nil, errOffsetKey
+	}
+	random := opts.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	k, err := readKeys(pad)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encrypter{
+		pad:     pad,
+		dst:     dst,
+		mac:     k.mac(),
+		left:    opts.Size,
+		padding: opts.Padding,
+	}
+	if !opts.Legacy {
+		id := padID(k.hmacKey)
+		h := &Header{Version: FormatVersion, PadID: id, Offset: opts.Offset, Sealed: SealOffset(opts.OffsetKey, id, opts.Offset)}
+		if opts.Padding > 0 {
+			h.Flags |= FlagPadded
+		}
+		if opts.Archive {
+			h.Flags |= FlagArchive
+		}
+		if err = e.emit(h.marshal()); err != nil {
+			return nil, err
+		}
+	}
+	iv := make([]byte, IvSize)
+	if _, err = io.ReadFull(random, iv); err != nil {
+		return nil, err
+	}
+	e.stream = cipher.NewCFBEncrypter(k.block(), iv)
+	if err = e.emit(iv); err != nil {
+		return nil, err
+	}
+	// Encrypted head: 8 bytes 0x00 and the big endian plaintext size
+	head := make([]byte, HeadSize)
+	binary.BigEndian.PutUint64(head[8:], uint64(opts.Size))
+	if err = e.encrypt(head); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// emit writes a ciphertext chunk and adds it to the HMAC.
+func (e *Encrypter) emit(buff []byte) error {
+	if _, err := e.dst.Write(buff); err != nil {
+		return err
+	}
+	e.mac.Write(buff)
+	return nil
+}
+
+// encrypt encrypts buff in place with the pad and AES, then emits it.
+func (e *Encrypter) encrypt(buff []byte) error {
+	if cap(e.padBuff) < len(buff) {
+		e.padBuff = make([]byte, len(buff))
+	}
+	padBuff := e.padBuff[:len(buff)]
+	if _, err := io.ReadFull(e.pad, padBuff); err != nil {
+		return err
+	}
+	xorBytes(buff, padBuff)
+	e.stream.XORKeyStream(buff, buff)
+	return e.emit(buff)
+}
+
+// Write encrypts p. Writing more than the announced size is an error.
+func (e *Encrypter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	if int64(len(p)) > e.left {
+		e.err = ErrSize
+		return 0, e.err
+	}
+	done := 0
+	for done < len(p) {
+		todo := len(p) - done
+		if int64(todo) > BufferSize {
+			todo = int(BufferSize)
+		}
+		buff := make([]byte, todo)
+		copy(buff, p[done:done+todo])
+		if e.err = e.encrypt(buff); e.err != nil {
+			return done, e.err
+		}
+		done += todo
+		e.left -= int64(todo)
+	}
+	return done, nil
+}
+
+// Close writes the 0x00 padding and the HMAC at the end of the ciphertext.
+// It does not close the underlying writer.
+func (e *Encrypter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.left != 0 {
+		e.err = ErrSize
+		return e.err
+	}
+	for e.padding > 0 {
+		todo := e.padding
+		if todo > BufferSize {
+			todo = BufferSize
+		}
+		// No xor with the plaintext here because padding value is 0x00
+		if e.err = e.encrypt(make([]byte, todo)); e.err != nil {
+			return e.err
+		}
+		e.padding -= todo
+	}
+	if _, e.err = e.dst.Write(e.mac.Sum(nil)); e.err != nil {
+		return e.err
+	}
+	e.err = errClosed
+	return nil
+}
@@ -14,235 +14,543 @@
 //   along with this program; if not, write to the Free Software Foundation,
 //   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
 
-package main
+// Package encrypt0 is the `crypt0 encrypt` command, also run as encrypt0.
+package encrypt0
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha512"
 	"fmt"
-	"hash"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/piotrcki/crypt0"
+	"github.com/piotrcki/crypt0/cli"
 )
 
-const ExitSuccess int = 0
-const ExitPadTooShort int = 1
-const ExitError int = 9
-const PadExt string = ".w.pad"
-const UsedPadExt string = ".x.pad"
-const CiphertextExt string = ".enc"
-const PadOverhead int64 = 144 // len(hmacKey) + len(AESKey) + len(head) = 96 + 32 + 16
-const BufferSize int64 = 1024 * 1024
+const PadExt string = crypt0.WritePadExt
+const StdStream string = "-"
+
+// Recipient is a pad the plaintext is encrypted with. A message has one, a
+// bundle has one per recipient.
+type Recipient struct {
+	PadName   string
+	LockName  string // the pad name when locked, it changes once exhausted
+	PadSize   int64
+	Available int64 // bytes of the pad that slices can still consume
+	Ledger    *crypt0.Ledger
+	Entry     *crypt0.Entry
+	Fpad      *os.File
+	Encrypter *crypt0.Encrypter
+	Written   int64 // ciphertext bytes handed to the destination, even if the write failed
+}
+
+// sliceWriter counts the ciphertext bytes written with the slice of a
+// recipient.
+type sliceWriter struct {
+	r   *Recipient
+	dst io.Writer
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	w.r.Written += int64(len(p))
+	return w.dst.Write(p)
+}
 
+var Args []string // the command line, Args[0] is the command name
 var Fplaintext *os.File = nil
 var Fciphertext *os.File = nil
-var Fpad *os.File = nil
 var PlaintextSize int64 = -1
-var PadSize int64 = -1
+var SliceSize int64 = -1 // bytes consumed from every pad
 var PlaintextName string = ""
 var CiphertextName string = ""
-var PadName string = ""
 var Short bool = false
+var Slice bool = false
+var Wipe string = crypt0.WipeNo
+var Armor bool = false
+var Archive bool = false // the plaintext is a directory
+var Peers []string
 
-var Hmac hash.Hash       // HMAC_SHA512
-var Cipher cipher.Stream // AES256_CFB
+var Recipients []*Recipient
+var Spool *crypt0.Spool = nil
+var Armorer io.WriteCloser = nil
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage:\n\n")
-	fmt.Fprintf(os.Stderr, "encrypt0 [--short] plaintext-file pad\n\n")
-	fmt.Fprintf(os.Stderr, "plaintext-file: the file to encrypt\n")
-	fmt.Fprintf(os.Stderr, "pad           : the pad to use (a .w.pad file)\n")
-	fmt.Fprintf(os.Stderr, "--short       : do not add padding to the plaintext, the ciphertext will be shorter but will leak the file size\n\n")
+	fmt.Fprintf(os.Stderr, "%s [--[no-]short] [--[no-]slice] [--[no-]armor] [--wipe|--no-wipe|--wipe-dry-run] [--peer peer]... [--] plaintext-file [pad...]\n\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "plaintext-file: the file or the directory to encrypt, or - to read the standard input and write the ciphertext to the standard output\n")
+	fmt.Fprintf(os.Stderr, "pad           : the pad to use (a .w.pad file), several pads make a bundle that every recipient can decrypt\n")
+	fmt.Fprintf(os.Stderr, "--peer        : use the write pad of this peer that best fits the plaintext according to the pad index, can be repeated\n")
+	fmt.Fprintf(os.Stderr, "--short       : do not add padding to the plaintext, the ciphertext will be shorter but will leak the file size\n")
+	fmt.Fprintf(os.Stderr, "--slice       : only consume the next unused slice of the pad instead of all of it, the slice is rounded up to a power of two unless --short is set\n")
+	fmt.Fprintf(os.Stderr, "--armor       : write an ASCII-armored ciphertext (a .enc.asc file) that can be pasted in a mail or a chat\n")
+	fmt.Fprintf(os.Stderr, "--no-...      : cancel --short, --slice or --armor set in the configuration\n")
+	fmt.Fprintf(os.Stderr, "--wipe        : overwrite the consumed slice of the pad with random data after the encryption, an exhausted pad is removed\n")
+	fmt.Fprintf(os.Stderr, "--no-wipe     : do not wipe the pad (the default unless CRYPT0_WIPE or the configuration says otherwise)\n")
+	fmt.Fprintf(os.Stderr, "--wipe-dry-run: only report what --wipe would do\n")
+	fmt.Fprintf(os.Stderr, "--            : end of the options, the following arguments are files even if they start with -\n\n")
 	fmt.Fprintf(os.Stderr, "Return values:\n\n")
 	fmt.Fprintf(os.Stderr, "0: encryption success\n")
 	fmt.Fprintf(os.Stderr, "1: pad is too short\n")
 	fmt.Fprintf(os.Stderr, "9: other error\n\n")
-	CleanExit(ExitError)
+	cli.Exit(cli.ExitError)
 }
 
-func FatalCheck(err error) {
-	if err != nil {
-		FatalError(err.Error())
-	}
-}
-
-func FatalError(err string) {
-	fmt.Fprintf(os.Stderr, "encrypt0: error: %s\n", err)
-	CleanExit(ExitError)
+func PadTooShort() {
+	cli.Error("the pad is too short.")
+	cli.Exit(cli.ExitPad)
 }
 
-func CleanExit(status int) {
+// Cleanup releases the resources, it is called by cli.Exit.
+func Cleanup(status int) {
 	if Fplaintext != nil {
 		Fplaintext.Close()
 	}
+	if Spool != nil {
+		Spool.Close()
+	}
 	if Fciphertext != nil {
 		Fciphertext.Close()
-		if status != ExitSuccess {
+		if (status != cli.ExitSuccess) && (Fciphertext != os.Stdout) {
 			os.Remove(CiphertextName)
 		}
 	}
-	if Fpad != nil {
-		Fpad.Close()
-		// No rollback on the pad name here
+	for _, r := range Recipients {
+		if r.Fpad != nil {
+			r.Fpad.Close()
+		}
+		if (r.Entry != nil) && (r.Entry.State == crypt0.StateReserved) {
+			// The slice can be given back only if no ciphertext byte was written:
+			// NewEncrypter may fail after writing the header
+			state := crypt0.StateBurned
+			if r.Written == 0 {
+				state = crypt0.StateReleased
+			}
+			if err := r.Ledger.Set(r.Entry, state); err != nil {
+				cli.Error(fmt.Sprintf("%s (run `pads0 recover`)", err))
+			}
+		}
+		if r.LockName != "" {
+			crypt0.UnlockPad(r.LockName)
+		}
 	}
-	os.Exit(status)
 }
 
 func ParseArgs() {
-	length := len(os.Args)
+	length := len(Args)
 	start := 1
-	if (length == 4) && (os.Args[1] == "--short") {
-		start = 2
-		Short = true
+	Wipe = cli.Conf.Wipe
+	Short = cli.Conf.Short
+	Slice = cli.Conf.Slice
+	Armor = cli.Conf.Armor
+	literal := false // after "--", every argument is positional
+	for ; !literal && (length > (start + 1)) && strings.HasPrefix(Args[start], "--"); start++ {
+		switch Args[start] {
+		case "--":
+			literal = true
+		case "--peer":
+			start++
+			Peers = append(Peers, Args[start])
+		case "--short":
+			Short = true
+		case "--no-short":
+			Short = false
+		case "--slice":
+			Slice = true
+		case "--no-slice":
+			Slice = false
+		case "--armor":
+			Armor = true
+		case "--no-armor":
+			Armor = false
+		case "--wipe":
+			Wipe = crypt0.WipeYes
+		case "--no-wipe":
+			Wipe = crypt0.WipeNo
+		case "--wipe-dry-run":
+			Wipe = crypt0.WipeDryRun
+		default:
+			Usage()
+		}
 	}
-	if length != (start + 2) {
+	if (length < (start + 2)) && ((length != (start + 1)) || (len(Peers) == 0)) {
 		Usage()
 	}
-	PlaintextName = os.Args[start]
-	PadName = os.Args[start+1]
-	indx := strings.Index(PadName, PadExt)
-	if (indx <= 0) || (indx != (len(PadName) - len(PadExt))) {
-		Usage()
+	PlaintextName = Args[start]
+	if literal {
+		PlaintextName = cli.Literal(PlaintextName)
+	}
+	for _, padName := range Args[start+1:] {
+		indx := strings.Index(padName, PadExt)
+		if (indx <= 0) || (indx != (len(padName) - len(PadExt))) {
+			Usage()
+		}
+		AddRecipient(padName)
+	}
+	CiphertextName = StdStream
+	if PlaintextName != StdStream {
+		CiphertextName = fmt.Sprintf("%s%s", filepath.Clean(PlaintextName), crypt0.CiphertextExt)
+		if Armor {
+			CiphertextName += crypt0.ArmorExt
+		}
+	}
+}
+
+func AddRecipient(padName string) {
+	for _, r := range Recipients {
+		if filepath.Clean(r.PadName) == filepath.Clean(padName) {
+			cli.FatalError(fmt.Sprintf("%s is given twice.", padName))
+		}
 	}
+	Recipients = append(Recipients, &Recipient{PadName: padName})
+}
+
+// SelectPads adds to the recipients the write pad of every peer that best
+// fits the plaintext, according to the pad index.
+func SelectPads() {
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	cli.FatalCheck(err)
+	if PlaintextName != StdStream {
+		inputInfo, err := os.Stat(PlaintextName)
+		cli.FatalCheck(err)
+		Archive = inputInfo.IsDir()
+		PlaintextSize = inputInfo.Size()
+	}
+	if (PlaintextName == StdStream) || Archive {
+		var limit int64 = -1
+		for _, r := range index.Pads {
+			if (r.Direction == crypt0.DirectionWrite) && (r.Remaining > limit) {
+				limit = r.Remaining
+			}
+		}
+		if limit < crypt0.PadOverhead {
+			PadTooShort()
+		}
+		SpoolInput(limit - crypt0.PadOverhead)
+	}
+	for _, peer := range Peers {
+		r := index.SelectPad(peer, PlaintextSize+crypt0.PadOverhead)
+		if r == nil {
+			cli.Error(fmt.Sprintf("no pad of `%s` is long enough (see `pads0 rebuild`).", peer))
+			cli.Exit(cli.ExitPad)
+		}
+		AddRecipient(r.Path)
+	}
+}
+
+func LockPads() {
+	for _, r := range Recipients {
+		if err := crypt0.LockPad(r.PadName); err != nil {
+			cli.FatalError(fmt.Sprintf("%s: %s", r.PadName, err))
+		}
+		r.LockName = r.PadName
+	}
+}
+
+// MinAvailable returns the bytes never consumed of the shortest pad.
+func MinAvailable() int64 {
+	available := Recipients[0].Available
+	for _, r := range Recipients {
+		if r.Available < available {
+			available = r.Available
+		}
+	}
+	return available
 }
 
 func CheckFiles() {
+	for _, r := range Recipients {
+		padInfo, err := os.Stat(r.PadName)
+		cli.FatalCheck(err)
+		if padInfo.Mode().IsRegular() == false {
+			cli.FatalError(fmt.Sprintf("%s is not a regular file.", r.PadName))
+		}
+		r.PadSize = padInfo.Size()
+		r.Ledger, err = crypt0.OpenLedger(r.PadName)
+		cli.FatalCheck(err)
+		if len(r.Ledger.Pending()) > 0 {
+			cli.FatalError(fmt.Sprintf("%s has an unfinished slice, run `pads0 recover`.", r.PadName))
+		}
+		r.Available = crypt0.Sliceable(r.PadSize) - r.Ledger.Next()
+		if r.Available < crypt0.PadOverhead {
+			PadTooShort()
+		}
+	}
+	if Spool != nil {
+		// Spooled to select the pads
+		if (MinAvailable() - PlaintextSize) < crypt0.PadOverhead {
+			PadTooShort()
+		}
+		return
+	}
+	if PlaintextName == StdStream {
+		// The size is only known once the standard input is spooled
+		return
+	}
 	inputInfo, err := os.Stat(PlaintextName)
-	FatalCheck(err)
-	padInfo, err := os.Stat(PadName)
-	FatalCheck(err)
-	if inputInfo.Mode().IsRegular() == false {
-		FatalError(fmt.Sprintf("%s is not a regular file.", PlaintextName))
+	cli.FatalCheck(err)
+	if inputInfo.IsDir() {
+		// The size is only known once the archive is spooled
+		Archive = true
+		return
 	}
-	if padInfo.Mode().IsRegular() == false {
-		FatalError(fmt.Sprintf("%s is not a regular file.", PadName))
+	if inputInfo.Mode().IsRegular() == false {
+		cli.FatalError(fmt.Sprintf("%s is not a regular file.", PlaintextName))
 	}
-	if (padInfo.Size() - inputInfo.Size()) < PadOverhead {
-		fmt.Fprintf(os.Stderr, "encrypt0: error: the pad is too short.\n")
-		os.Exit(ExitPadTooShort)
+	if (MinAvailable() - inputInfo.Size()) < crypt0.PadOverhead {
+		PadTooShort()
 	}
 	PlaintextSize = inputInfo.Size()
-	PadSize = padInfo.Size()
 }
 
-func GetHeader() []byte {
-	ret := make([]byte, 16)
-	var div int64 = 1
-	for i := 0; i < 8; i++ {
-		ret[i] = 0
+// SpoolInput buffers the standard input or the archive of the directory
+// (encrypted with a throw-away key) to learn its size before the pad gets
+// consumed. More than limit bytes make the pad too short.
+func SpoolInput(limit int64) {
+	var err error
+	var input io.Reader = os.Stdin
+	if Archive {
+		reader, writer := io.Pipe()
+		defer reader.Close()
+		go func() {
+			writer.CloseWithError(crypt0.WriteArchive(writer, PlaintextName, cli.Warning))
+		}()
+		input = reader
 	}
-	for i := 15; i > 7; i-- {
-		ret[i] = byte((PlaintextSize / div) % 256)
-		div *= 256
+	Spool, err = crypt0.NewSpool(input, limit)
+	if err == crypt0.ErrPadTooShort {
+		PadTooShort()
 	}
-	return ret
+	cli.FatalCheck(err)
+	PlaintextSize = Spool.Size()
 }
 
-func Init() {
-	// Opening files
+// OpenFiles creates the ciphertext before the slices are reserved, so that a
+// reserved slice without ciphertext can only be the result of a crash after
+// the ciphertext was moved.
+func OpenFiles() {
 	var err error
-	Fplaintext, err = os.Open(PlaintextName)
-	FatalCheck(err)
-	CiphertextName = fmt.Sprintf("%s%s", PlaintextName, CiphertextExt)
+	if PlaintextName == StdStream {
+		Fciphertext = os.Stdout
+		return
+	}
+	if !Archive {
+		Fplaintext, err = os.Open(PlaintextName)
+		cli.FatalCheck(err)
+	}
 	Fciphertext, err = os.Create(CiphertextName)
-	newPadName := strings.Replace(PadName, PadExt, UsedPadExt, -1)
-	err = os.Rename(PadName, newPadName)
-	FatalCheck(err)
-	PadName = newPadName
-	Fpad, err = os.Open(PadName)
-	FatalCheck(err)
-	// Setting up HMAC
-	hmacKey := make([]byte, 96)
-	_, err = io.ReadFull(Fpad, hmacKey)
-	FatalCheck(err)
-	Hmac = hmac.New(sha512.New, hmacKey)
-	// Setting up AES
-	iv := make([]byte, 16)
-	_, err = rand.Read(iv) // There is a ReadFull inside rand.Read
-	FatalCheck(err)
-	_, err = Fciphertext.Write(iv)
-	FatalCheck(err)
-	Hmac.Write(iv)
-	aesKey := make([]byte, 32)
-	_, err = io.ReadFull(Fpad, aesKey)
-	FatalCheck(err)
-	AES, err := aes.NewCipher(aesKey)
-	FatalCheck(err)
-	Cipher = cipher.NewCFBEncrypter(AES, iv)
+	cli.FatalCheck(err)
+}
+
+// ReservePads records in the ledgers the slices of the pads that are going to
+// be consumed, before they are read. All the slices have the size of the
+// shortest one, so that the sections of a bundle have the same size.
+func ReservePads() {
+	var err error
+	for _, r := range Recipients {
+		var padding int64 = 0
+		length := r.Available
+		if Slice {
+			if !Short {
+				padding, err = crypt0.SlicePadding(r.Available, PlaintextSize)
+				cli.FatalCheck(err)
+			}
+			length = PlaintextSize + crypt0.PadOverhead + padding
+		}
+		if (SliceSize < 0) || (length < SliceSize) {
+			SliceSize = length
+		}
+	}
+	for _, r := range Recipients {
+		r.Entry, err = r.Ledger.Reserve(SliceSize, r.PadSize, CiphertextName)
+		cli.FatalCheck(err)
+	}
+}
+
+// Padding returns the padding of the plaintext in every ciphertext.
+func Padding() int64 {
+	if Short {
+		return 0
+	}
+	padding, err := crypt0.Padding(SliceSize, PlaintextSize)
+	cli.FatalCheck(err)
+	return padding
 }
 
+// Encrypt writes the ciphertext or, with several recipients, the bundle of
+// their ciphertexts in a random order.
 func Encrypt() {
-	// Getting and encrypt the header
-	head := GetHeader()
-	headPad := make([]byte, 16)
-	_, err := io.ReadFull(Fpad, headPad)
-	FatalCheck(err)
-	for i := 0; i < 16; i++ {
-		head[i] ^= headPad[i]
-	}
-	Cipher.XORKeyStream(head, head)
-	_, err = Fciphertext.Write(head)
-	FatalCheck(err)
-	Hmac.Write(head)
-	// Encrypting the plaintext
-	// Blocks are to avoid buffering all the file
-	var blocks int64 = (PlaintextSize / BufferSize) + 1
-	var i, j int64
-	for i = 0; i < blocks; i++ {
-		todo := BufferSize
-		if i == (blocks - 1) {
-			todo = PlaintextSize % BufferSize
-		}
-		if todo == 0 {
-			continue
-		}
-		buff := make([]byte, todo)
-		padBuff := make([]byte, todo)
-		_, err = io.ReadFull(Fplaintext, buff)
-		FatalCheck(err)
-		_, err = io.ReadFull(Fpad, padBuff)
-		FatalCheck(err)
-		for j = 0; j < todo; j++ {
-			buff[j] ^= padBuff[j]
-		}
-		Cipher.XORKeyStream(buff, buff)
-		_, err = Fciphertext.Write(buff)
-		Hmac.Write(buff)
-	}
-	// Adding and encrypting 0x00 padding
-	if !Short {
-		var toWrite int64 = PadSize - PadOverhead - PlaintextSize
-		blocks = (toWrite / BufferSize) + 1
-		for i = 0; i < blocks; i++ {
-			todo := BufferSize
-			if i == (blocks - 1) {
-				todo = toWrite % BufferSize
+	var err error
+	var dst io.Writer = Fciphertext
+	if Armor {
+		Armorer, err = crypt0.NewArmorWriter(Fciphertext)
+		cli.FatalCheck(err)
+		dst = Armorer
+	}
+	order := []int{0}
+	if len(Recipients) > 1 {
+		order, err = crypt0.BundleOrder(len(Recipients))
+		cli.FatalCheck(err)
+		bundle := &crypt0.Bundle{
+			Count:       int64(len(Recipients)),
+			SectionSize: int64(crypt0.HeaderSize) + crypt0.CiphertextOverhead + PlaintextSize + Padding(),
+		}
+		cli.FatalCheck(crypt0.WriteBundleHeader(dst, bundle))
+	}
+	for _, i := range order {
+		EncryptFor(Recipients[i], dst)
+	}
+	if Armorer != nil {
+		cli.FatalCheck(Armorer.Close())
+	}
+	if Fciphertext != os.Stdout {
+		cli.FatalCheck(Fciphertext.Sync())
+	}
+}
+
+// EncryptFor writes the ciphertext of a recipient.
+func EncryptFor(r *Recipient, dst io.Writer) {
+	var err error
+	var input io.Reader = Fplaintext
+	if Spool != nil {
+		cli.FatalCheck(Spool.Rewind())
+		input = Spool
+	} else {
+		_, err = Fplaintext.Seek(0, 0)
+		cli.FatalCheck(err)
+	}
+	r.Fpad, err = os.Open(r.PadName)
+	cli.FatalCheck(err)
+	key, err := crypt0.ReadOffsetKey(r.Fpad, r.PadSize)
+	cli.FatalCheck(err)
+	_, err = r.Fpad.Seek(r.Entry.Offset, 0)
+	cli.FatalCheck(err)
+	// Setting up the cipher
+	opts := &crypt0.Options{Size: PlaintextSize, Padding: Padding(), Offset: r.Entry.Offset, OffsetKey: key, Archive: Archive}
+	r.Encrypter, err = crypt0.NewEncrypter(r.Fpad, &sliceWriter{r, dst}, opts)
+	cli.FatalCheck(err)
+	_, err = io.CopyN(r.Encrypter, input, PlaintextSize)
+	cli.FatalCheck(err)
+	// Writing the padding and the HMAC at the end of the ciphertext
+	cli.FatalCheck(r.Encrypter.Close())
+}
+
+// Commit marks the slices as used once the ciphertext is safely written. An
+// exhausted pad is renamed before its ledger: a crash in between leaves a used
+// pad, never a fresh pad without its ledger.
+func Commit() {
+	for _, r := range Recipients {
+		cli.FatalCheck(r.Ledger.Set(r.Entry, crypt0.StateUsed))
+		if r.Ledger.Exhausted(r.PadSize) {
+			// The ciphertext is valid whatever happens now
+			newPadName := crypt0.UsedPadName(r.PadName)
+			err := os.Rename(r.PadName, newPadName)
+			if err == nil {
+				r.PadName = newPadName
+				err = r.Ledger.Rename(r.PadName)
 			}
-			padBuff := make([]byte, todo)
-			_, err = io.ReadFull(Fpad, padBuff)
-			FatalCheck(err)
-			// No xor loop here because padding value is 0x00
-			Cipher.XORKeyStream(padBuff, padBuff)
-			_, err = Fciphertext.Write(padBuff)
-			FatalCheck(err)
-			Hmac.Write(padBuff)
+			if err != nil {
+				cli.Warning(fmt.Sprintf("%s (run `pads0 recover`)", err))
+			}
+		}
+	}
+}
+
+// WipePad destroys the consumed slice, or the whole pad once exhausted, so
+// that whoever seizes the machine later cannot decrypt past messages.
+func WipePad(r *Recipient, report *os.File) {
+	if Wipe == crypt0.WipeNo {
+		return
+	}
+	r.Fpad.Close()
+	r.Fpad = nil
+	exhausted := r.Ledger.Exhausted(r.PadSize)
+	if Wipe == crypt0.WipeDryRun {
+		if exhausted {
+			fmt.Fprintf(report, "%s: dry-run: `%s` would be wiped and removed.\n", cli.Name, r.PadName)
+		} else {
+			fmt.Fprintf(report, "%s: dry-run: bytes %d to %d of `%s` would be wiped.\n", cli.Name,
+				r.Entry.Offset, r.Entry.End()-1, r.PadName)
+		}
+		return
+	}
+	var err error
+	if exhausted {
+		err = crypt0.DestroyPad(r.PadName)
+	} else {
+		err = crypt0.WipeRange(r.PadName, r.Entry.Range)
+	}
+	if err != nil {
+		// The ciphertext is valid, this is not a reason to fail
+		cli.Warning(fmt.Sprintf("`%s` not wiped: %s", r.PadName, err))
+	} else if exhausted {
+		fmt.Fprintf(report, "%s: `%s` wiped and removed.\n", cli.Name, r.PadName)
+	} else {
+		fmt.Fprintf(report, "%s: bytes %d to %d of `%s` wiped.\n", cli.Name,
+			r.Entry.Offset, r.Entry.End()-1, r.PadName)
+	}
+}
+
+// UpdateIndex records the new state of the pads in the pad index.
+func UpdateIndex() {
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	for _, r := range Recipients {
+		if err != nil {
+			break
+		}
+		var path, lockPath string
+		if path, err = filepath.Abs(r.PadName); err != nil {
+			break
+		}
+		if lockPath, err = filepath.Abs(r.LockName); err != nil {
+			break
+		}
+		if lockPath != path {
+			// Renamed once exhausted
+			index.Remove(lockPath)
 		}
+		var record *crypt0.PadRecord
+		if record, err = index.Update(path); record != nil {
+			record.LastUsed = time.Now().UTC()
+		}
+	}
+	if err == nil {
+		err = index.Save()
+	}
+	if err != nil {
+		cli.Warning(fmt.Sprintf("pad index not updated: %s", err))
 	}
-	// Writing the HMAC at the end of the ciphertext
-	_, err = Fciphertext.Write(Hmac.Sum(nil))
-	FatalCheck(err)
 }
 
-func main() {
+// Main runs the command with its arguments.
+func Main(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	cli.AtExit(Cleanup)
 	ParseArgs()
+	if len(Peers) > 0 {
+		SelectPads()
+	}
+	LockPads()
 	CheckFiles()
-	Init()
+	if ((PlaintextName == StdStream) || Archive) && (Spool == nil) {
+		SpoolInput(MinAvailable() - crypt0.PadOverhead)
+	}
+	OpenFiles()
+	ReservePads()
 	Encrypt()
-	fmt.Printf("encrypt0: success: `%s` successfully encrypted using `%s`.\n",
-		PlaintextName, PadName)
-	CleanExit(ExitSuccess)
+	Commit()
+	report := os.Stdout
+	if Fciphertext == os.Stdout {
+		report = os.Stderr
+	}
+	for _, r := range Recipients {
+		fmt.Fprintf(report, "%s: success: `%s` successfully encrypted using `%s` (bytes %d to %d).\n", cli.Name,
+			PlaintextName, r.PadName, r.Entry.Offset, r.Entry.End()-1)
+	}
+	for _, r := range Recipients {
+		WipePad(r, report)
+	}
+	UpdateIndex()
+	cli.Exit(cli.ExitSuccess)
 }
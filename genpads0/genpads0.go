@@ -14,143 +14,357 @@
 //   along with this program; if not, write to the Free Software Foundation,
 //   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
 
-package main
+// Package genpads0 is the `crypt0 genpads` command, also run as genpads0.
+package genpads0
 
 import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha512"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
+
+	"github.com/piotrcki/crypt0"
+	"github.com/piotrcki/crypt0/cli"
 )
 
-const ExitSuccess int = 0
-const ExitError int = 9
 const DirExt string = ".pads"
 
+var Args []string // the command line, Args[0] is the command name
 var Todo [][]string
 var Number uint64
 var Size uint64
-var Cipher cipher.Stream // AES256_CTR
+var Workers int = runtime.NumCPU() // pads generated at the same time
 var Sources []*os.File
+var Health []*crypt0.HealthTest // of the Sources
+var Labels []string             // of the sources, crypto/rand first, see crypt0.ExtractSeed
+var SourceLock sync.Mutex       // held while reading the Sources
+var Queue []*Job
+var Channels [][2]string            // the two directories of every channel, see WriteManifests
+var Partial = make(map[string]bool) // the pads being written, removed if the generation fails
+var PartialLock sync.Mutex
+var Failed int32 // set to 1 when a worker fails
+var DonePads int64
+var DoneBytes int64
+
+// ChunkSize is the size of the buffers of a worker.
+const ChunkSize int = 1024 * 1024
+
+// Job is a pad to generate, with its copy if PadCopy is not empty.
+type Job struct {
+	PadName string
+	PadCopy string
+	Sum     []byte // SHA-512 of the pad, once generated
+}
+
+// Worker generates pads with its own buffers.
+type Worker struct {
+	Buffer []byte
+	Tmp    []byte
+	Health *crypt0.HealthTest // of its crypto/rand output
+}
+
+func NewWorker() *Worker {
+	return &Worker{
+		Buffer: make([]byte, ChunkSize),
+		Tmp:    make([]byte, ChunkSize),
+		Health: crypt0.NewHealthTest("crypto/rand", crypt0.SourceEntropy),
+	}
+}
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage:\n\n")
-	fmt.Fprintf(os.Stderr, "form 1: genpads0 size pad-name\n")
-	fmt.Fprintf(os.Stderr, "form 2: genpads0 size number peer1 peer2\n")
-	fmt.Fprintf(os.Stderr, "form 3: genpads0 size number peers-file\n\n")
+	fmt.Fprintf(os.Stderr, "form 1: %s [--jobs n] size pad-name\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "form 2: %s [--jobs n] size number peer1 peer2\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "form 3: %s [--jobs n] size number peers-file\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "form 4: %s --test pad-or-directory...\n\n", cli.Name)
 	fmt.Fprintf(os.Stderr, "size      : size of a pad in kio (1 kio = 1024 bytes)\n")
 	fmt.Fprintf(os.Stderr, "pad-name  : file name of the pad to generate\n")
 	fmt.Fprintf(os.Stderr, "number    : number of pads to generate per communication way\n")
 	fmt.Fprintf(os.Stderr, "peer1|2   : peer's name (Such as \"Alice\" or \"Bob\"\n")
 	fmt.Fprintf(os.Stderr, "peers-file: a CSV file containing communication channel between peers\n")
-	fmt.Fprintf(os.Stderr, "            each line is on the following form SENDER,RECIPIENT1[,RECIPIENT2[...]]\n\n")
+	fmt.Fprintf(os.Stderr, "            each line is on the following form SENDER,RECIPIENT1[,RECIPIENT2[...]]\n")
+	fmt.Fprintf(os.Stderr, "--jobs    : number of pads generated at the same time (the number of CPUs by default)\n")
+	fmt.Fprintf(os.Stderr, "--test    : run statistical tests on the pads (the .w.pad and .r.pad files of the directories) instead of generating pads\n\n")
 	fmt.Fprintf(os.Stderr, "Environment:\n\n")
-	fmt.Fprintf(os.Stderr, "CSTRNG: cryptographically secure true random number generator. Readable file expected (multiple files can be supplied separated by ':')\n")
-	fmt.Fprintf(os.Stderr, "PRNG  : pseudo-random number generator. Readable file expected (multiple files can be supplied separated by ':')\n\n")
+	fmt.Fprintf(os.Stderr, "CSTRNG: cryptographically secure true random number generator. Readable file expected (multiple files can be supplied separated by ':', `cstrng` in the configuration)\n")
+	fmt.Fprintf(os.Stderr, "PRNG  : pseudo-random number generator. Readable file expected (multiple files can be supplied separated by ':', `prng` in the configuration)\n\n")
+	fmt.Fprintf(os.Stderr, "Every source, the generator of the system included, goes through continuous health tests:\n")
+	fmt.Fprintf(os.Stderr, "the generation is aborted and the unfinished pads removed when a source looks stuck, biased or repeating.\n\n")
 	fmt.Fprintf(os.Stderr, "Return values:\n\n")
 	fmt.Fprintf(os.Stderr, "0: success\n")
-	fmt.Fprintf(os.Stderr, "9: error\n")
-	os.Exit(ExitError)
+	fmt.Fprintf(os.Stderr, "1: a pad failed a statistical test (form 4)\n")
+	fmt.Fprintf(os.Stderr, "9: error or failed health test\n")
+	cli.Exit(cli.ExitError)
 }
 
-func FatalCheck(err error) {
-	if err != nil {
-		FatalError(err.Error())
+// Cleanup releases the resources, it is called by cli.Exit.
+func Cleanup(status int) {
+	if status != cli.ExitSuccess {
+		PartialLock.Lock()
+		for name := range Partial {
+			os.Remove(name)
+		}
+		PartialLock.Unlock()
 	}
-}
-
-func FatalError(err string) {
-	fmt.Fprintf(os.Stderr, "encrypt0: error: %s\n", err)
-	CleanExit(ExitError)
-}
-
-func CleanExit(status int) {
 	for _, f := range Sources {
 		if f != nil {
 			f.Close()
 		}
 	}
-	os.Exit(status)
 }
 
+// Read fills p from a source and runs its health tests.
+func Read(src io.Reader, health *crypt0.HealthTest, p []byte) error {
+	if _, err := io.ReadFull(src, p); err != nil {
+		return err
+	}
+	if err := health.Check(p); err != nil {
+		return fmt.Errorf("%s, pad generation aborted", err)
+	}
+	return nil
+}
+
+// InitRandom checks the seed extractor and opens the sources.
 func InitRandom() {
-	var sources []string
-	sources = append(sources, strings.Split(os.Getenv("CSTRNG"), ":")...)
-	if len(sources) == 0 || ((len(sources) == 1) && (sources[0] == "")) {
-		fmt.Printf("genpads0: warning: no CSTRNG in use (this should remain secure in most cases).\n")
-	}
-	sources = append(sources, strings.Split(os.Getenv("PRNG"), ":")...)
-	for _, source := range sources {
-		if source != "" {
+	if err := crypt0.SeedSelfTest(); err != nil {
+		cli.FatalError(err.Error())
+	}
+	Labels = []string{crypt0.SeedLabel(0, "system")}
+	for _, kind := range []string{"cstrng", "prng"} {
+		sources := cli.Conf.CSTRNG
+		if kind == "prng" {
+			sources = cli.Conf.PRNG
+		}
+		for _, source := range strings.Split(sources, ":") {
+			if source == "" {
+				continue
+			}
 			f, err := os.Open(source)
-			FatalCheck(err)
+			cli.FatalCheck(err)
 			Sources = append(Sources, f)
+			Health = append(Health, crypt0.NewHealthTest(source, crypt0.SourceEntropy))
+			Labels = append(Labels, crypt0.SeedLabel(len(Labels), kind))
+		}
+		if (kind == "cstrng") && (len(Labels) == 1) {
+			fmt.Printf("%s: warning: no CSTRNG in use (this should remain secure in most cases).\n", cli.Name)
 		}
 	}
-	iv := make([]byte, 16)
-	aesKey := make([]byte, 32)
-	_, err := rand.Read(iv) // There is a ReadFull inside rand.Read
-	FatalCheck(err)
-	_, err = rand.Read(aesKey)
-	FatalCheck(err)
-	for _, f := range Sources {
-		var i uint64
-		_iv := make([]byte, 16)
-		_aesKey := make([]byte, 32)
-		_, err = io.ReadFull(f, iv)
-		FatalCheck(err)
-		_, err = io.ReadFull(f, aesKey)
-		FatalCheck(err)
-		for i = 0; i < 16; i++ {
-			iv[i] ^= _iv[i]
-		}
-		for i = 0; i < 32; i++ {
-			aesKey[i] ^= _aesKey[i]
-		}
-	}
-	AES, err := aes.NewCipher(aesKey)
-	FatalCheck(err)
-	Cipher = cipher.NewCTR(AES, iv)
-}
-
-func GeneratePad(padName, padCopy string) {
-	var file1 *os.File = nil
-	var file2 *os.File = nil
-	file1, err := os.Create(padName)
-	FatalCheck(err)
-	defer file1.Close()
-	if padCopy != "" {
-		file2, err = os.Create(padCopy)
-		FatalCheck(err)
-		defer file2.Close()
-	}
-	buffer := make([]byte, 1024)
-	var i, j uint64
-	for i = 0; i < Size; i++ {
-		_, err = rand.Read(buffer)
-		FatalCheck(err)
-		for _, f := range Sources {
-			_buffer := make([]byte, 1024)
-			_, err := io.ReadFull(f, _buffer)
-			FatalCheck(err)
-			for j = 0; j < 1024; j++ {
-				buffer[j] ^= _buffer[j]
+}
+
+// ReadSources fills p with the xor of all the sources, tmp is a buffer of the
+// same size. The external sources are read by one worker at a time, so that
+// their health tests see their whole output in order.
+func (w *Worker) ReadSources(p, tmp []byte) error {
+	if err := Read(rand.Reader, w.Health, p); err != nil {
+		return err
+	}
+	SourceLock.Lock()
+	defer SourceLock.Unlock()
+	for k, f := range Sources {
+		if err := Read(f, Health[k], tmp); err != nil {
+			return err
+		}
+		for i := range p {
+			p[i] ^= tmp[i]
+		}
+	}
+	return nil
+}
+
+// NewCipher returns an AES-256 cipher in CTR mode seeded by crypt0.ExtractSeed
+// over crypt0.SeedInput bytes of every source: each pad has its own cipher.
+func (w *Worker) NewCipher() (cipher.Stream, error) {
+	inputs := make([][]byte, len(Labels))
+	for i := range inputs {
+		inputs[i] = make([]byte, crypt0.SeedInput)
+	}
+	if err := Read(rand.Reader, w.Health, inputs[0]); err != nil {
+		return nil, err
+	}
+	SourceLock.Lock()
+	for k, f := range Sources {
+		if err := Read(f, Health[k], inputs[k+1]); err != nil {
+			SourceLock.Unlock()
+			return nil, err
+		}
+	}
+	SourceLock.Unlock()
+	seed := crypt0.ExtractSeed(Labels, inputs)
+	AES, err := aes.NewCipher(seed[:32])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(AES, seed[32:]), nil
+}
+
+// Create creates a pad, removed by Cleanup until Done is called. An existing
+// file is never overwritten.
+func Create(padName string) (*os.File, error) {
+	f, err := os.OpenFile(padName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("`%s` exists, pads are never overwritten", padName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	PartialLock.Lock()
+	Partial[padName] = true
+	PartialLock.Unlock()
+	return f, nil
+}
+
+// Done closes a pad and marks it as complete.
+func Done(pad *os.File) error {
+	err := pad.Sync()
+	if cerr := pad.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		PartialLock.Lock()
+		delete(Partial, pad.Name())
+		PartialLock.Unlock()
+	}
+	return err
+}
+
+// GeneratePad writes a pad, and its copy if any, by chunks of ChunkSize.
+func (w *Worker) GeneratePad(job *Job) error {
+	if atomic.LoadInt32(&Failed) != 0 {
+		return nil
+	}
+	stream, err := w.NewCipher()
+	if err != nil {
+		return err
+	}
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range []string{job.PadName, job.PadCopy} {
+		if name != "" {
+			f, err := Create(name)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+	}
+	sum := sha512.New()
+	for remaining := int64(Size) * 1024; remaining > 0; {
+		if atomic.LoadInt32(&Failed) != 0 {
+			return nil
+		}
+		n := int64(len(w.Buffer))
+		if remaining < n {
+			n = remaining
+		}
+		buffer := w.Buffer[:n]
+		if err = w.ReadSources(buffer, w.Tmp[:n]); err != nil {
+			return err
+		}
+		stream.XORKeyStream(buffer, buffer)
+		sum.Write(buffer)
+		for _, f := range files {
+			if _, err = f.Write(buffer); err != nil {
+				return err
+			}
+		}
+		remaining -= n
+		atomic.AddInt64(&DoneBytes, n)
+	}
+	for _, f := range files {
+		if err = Done(f); err != nil {
+			return err
+		}
+	}
+	files = nil
+	job.Sum = sum.Sum(nil)
+	atomic.AddInt64(&DonePads, 1)
+	return nil
+}
+
+// ShowProgress prints the progress on the terminal until stop is closed.
+func ShowProgress(total int64, start time.Time, stop chan bool) {
+	info, err := os.Stderr.Stat()
+	terminal := (err == nil) && (info.Mode()&os.ModeCharDevice != 0)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if terminal {
+				fmt.Fprintf(os.Stderr, "\r\033[K")
+			}
+			return
+		case <-ticker.C:
+			if terminal {
+				done := atomic.LoadInt64(&DoneBytes)
+				fmt.Fprintf(os.Stderr, "\r\033[K%s: %d/%d pads, %s of %s, %s/s", cli.Name, atomic.LoadInt64(&DonePads),
+					len(Queue), MiB(done), MiB(total), MiB(int64(float64(done)/time.Since(start).Seconds())))
 			}
 		}
-		Cipher.XORKeyStream(buffer, buffer)
-		_, err = file1.Write(buffer)
-		FatalCheck(err)
-		if file2 != nil {
-			_, err = file2.Write(buffer)
-			FatalCheck(err)
+	}
+}
+
+// MiB formats a number of bytes.
+func MiB(n int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
+}
+
+// Generate generates the pads of the queue with Workers workers and reports
+// the throughput.
+func Generate() {
+	start := time.Now()
+	total := int64(Size) * 1024 * int64(len(Queue))
+	jobs := make(chan *Job)
+	errs := make(chan error, Workers)
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	go ShowProgress(total, start, stop)
+	for i := 0; (i < Workers) && (i < len(Queue)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewWorker()
+			for job := range jobs {
+				if err := w.GeneratePad(job); err != nil {
+					if atomic.CompareAndSwapInt32(&Failed, 0, 1) {
+						errs <- err
+					}
+				}
+			}
+		}()
+	}
+	for _, job := range Queue {
+		if atomic.LoadInt32(&Failed) != 0 {
+			break
 		}
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+	close(stop)
+	if atomic.LoadInt32(&Failed) != 0 {
+		cli.FatalError((<-errs).Error())
 	}
+	elapsed := time.Since(start)
+	fmt.Printf("%s: %d pads, %s generated in %s (%s/s).\n", cli.Name, len(Queue), MiB(total),
+		elapsed.Round(time.Millisecond), MiB(int64(float64(total)/elapsed.Seconds())))
 }
 
 func DoTheWork() {
@@ -163,31 +377,186 @@ func DoTheWork() {
 					os.PathSeparator, Todo[i][j])
 				rDir := fmt.Sprintf("%s%s%c%s", Todo[i][j], DirExt,
 					os.PathSeparator, Todo[i][0])
-				FatalCheck(os.MkdirAll(wDir, 0700))
-				FatalCheck(os.MkdirAll(rDir, 0700))
-				baseName := strconv.FormatInt(time.Now().UnixNano(), 16)
-				GeneratePad(fmt.Sprintf("%s%c%s.w.pad", wDir, os.PathSeparator, baseName),
-					fmt.Sprintf("%s%c%s.r.pad", rDir, os.PathSeparator, baseName))
+				cli.FatalCheck(os.MkdirAll(wDir, 0700))
+				cli.FatalCheck(os.MkdirAll(rDir, 0700))
+				baseName, err := crypt0.NewPadName()
+				cli.FatalCheck(err)
+				Queue = append(Queue, &Job{PadName: fmt.Sprintf("%s%c%s.w.pad", wDir, os.PathSeparator, baseName),
+					PadCopy: fmt.Sprintf("%s%c%s.r.pad", rDir, os.PathSeparator, baseName)})
+				AddChannel(wDir, rDir)
 			}
 		}
 	}
+	Generate()
+	WriteManifests()
 }
 
-func main() {
+// AddChannel records the two directories of a channel, once.
+func AddChannel(dir1, dir2 string) {
+	for _, c := range Channels {
+		if ((c[0] == dir1) && (c[1] == dir2)) || ((c[0] == dir2) && (c[1] == dir1)) {
+			return
+		}
+	}
+	Channels = append(Channels, [2]string{dir1, dir2})
+}
+
+// KeyPad returns a pad that the owner of a directory of pads, alice for
+// alice.pads/bob, already shares with this host, or "" if there is none. Its
+// offset key authenticates the manifest of the directory (see
+// crypt0.ManifestKey). The newest pad is taken, the most likely to be still
+// held by the owner.
+func KeyPad(dir string) string {
+	owner := strings.TrimSuffix(filepath.Base(filepath.Dir(dir)), DirExt)
+	pads, err := crypt0.FindPads(filepath.Join(crypt0.PeersDir(), owner))
+	if os.IsNotExist(err) {
+		return ""
+	}
+	cli.FatalCheck(err)
+	ret := ""
+	var newest time.Time
+	for _, padName := range pads {
+		info, err := os.Stat(padName)
+		cli.FatalCheck(err)
+		if strings.HasSuffix(padName, crypt0.UsedPadExt) || (info.Size() < crypt0.OffsetKeySize) {
+			continue
+		}
+		if (ret == "") || info.ModTime().After(newest) {
+			ret, newest = padName, info.ModTime()
+		}
+	}
+	return ret
+}
+
+// WriteManifests writes the manifests of the two directories of every channel:
+// they list every fresh pad of the directories, those that were not generated
+// now are hashed again. Each manifest is authenticated with a pad shared with
+// the owner of its directory, if any.
+func WriteManifests() {
+	sums := make(map[string][]byte)
+	for _, job := range Queue {
+		sums[job.PadName] = job.Sum
+		sums[job.PadCopy] = job.Sum
+	}
+	for _, c := range Channels {
+		var codes [2]string
+		for i, dir := range c {
+			pads, err := crypt0.ManifestPads(dir)
+			cli.FatalCheck(err)
+			var entries []*crypt0.ManifestEntry
+			for _, padName := range pads {
+				e := &crypt0.ManifestEntry{Name: filepath.Base(padName), Size: int64(Size) * 1024, Sum: sums[padName]}
+				if e.Sum == nil {
+					e, err = crypt0.HashPad(padName)
+					cli.FatalCheck(err)
+				}
+				entries = append(entries, e)
+			}
+			keyPad := KeyPad(dir)
+			cli.FatalCheck(crypt0.WriteManifest(dir, entries, keyPad))
+			codes[i] = crypt0.ManifestCode(entries)
+			if keyPad != "" {
+				fmt.Printf("%s: manifest of `%s` authenticated with `%s`.\n", cli.Name, dir, keyPad)
+			} else {
+				fmt.Printf("%s: manifest of `%s` not authenticated, no pad shared with its owner.\n", cli.Name, dir)
+			}
+		}
+		if codes[0] != codes[1] {
+			cli.Warning(fmt.Sprintf("`%s` (code %s) and `%s` (code %s) do not hold the same pads.", c[0], codes[0], c[1], codes[1]))
+			continue
+		}
+		fmt.Printf("%s: manifests of `%s` and `%s` written, code %s.\n", cli.Name, c[0], c[1], codes[0])
+	}
+}
+
+// TestPads runs the statistical tests on the pads and directories of pads and
+// prints a report, it exits with cli.ExitPad if a pad fails a test.
+func TestPads(names []string) {
+	var pads []string
+	for _, name := range names {
+		err := filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if (path == name) && !info.IsDir() {
+				pads = append(pads, path)
+			} else if info.Mode().IsRegular() &&
+				(strings.HasSuffix(path, crypt0.WritePadExt) || strings.HasSuffix(path, crypt0.ReadPadExt)) {
+				pads = append(pads, path)
+			}
+			return nil
+		})
+		cli.FatalCheck(err)
+	}
+	if len(pads) == 0 {
+		cli.FatalError("no pad to test.")
+	}
+	buffer := make([]byte, 1024*1024)
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "PAD\tTEST\tVALUE\tP-VALUE\tRESULT\n")
+	for _, padName := range pads {
+		f, err := os.Open(padName)
+		cli.FatalCheck(err)
+		test := crypt0.NewStatTest()
+		_, err = io.CopyBuffer(test, f, buffer)
+		f.Close()
+		cli.FatalCheck(err)
+		results := test.Results()
+		if results == nil {
+			cli.FatalError(fmt.Sprintf("`%s` is empty.", padName))
+		}
+		pass := true
+		for _, r := range results {
+			p, result := "-", "pass"
+			if !math.IsNaN(r.P) {
+				p = fmt.Sprintf("%.4f", r.P)
+			}
+			if !r.Pass {
+				result, pass = "FAIL", false
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.6g\t%s\t%s\n", padName, r.Test, r.Value, p, result)
+		}
+		if !pass {
+			failed++
+		}
+	}
+	w.Flush()
+	fmt.Printf("%s: %d pads tested, %d failed (significance level %g).\n", cli.Name, len(pads), failed, crypt0.StatAlpha)
+	if failed > 0 {
+		cli.Exit(cli.ExitPad)
+	}
+	cli.Exit(cli.ExitSuccess)
+}
+
+// Main runs the command with its arguments.
+func Main(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	cli.AtExit(Cleanup)
 	var err error
-	if len(os.Args) == 3 {
-		Size, err = strconv.ParseUint(os.Args[1], 10, 64)
+	if (len(Args) >= 3) && (Args[1] == "--jobs") {
+		Workers, err = strconv.Atoi(Args[2])
+		if (err != nil) || (Workers < 1) {
+			Usage()
+		}
+		Args = append(Args[:1], Args[3:]...)
+	}
+	if (len(Args) >= 3) && (Args[1] == "--test") {
+		TestPads(Args[2:])
+	} else if len(Args) == 3 {
+		Size, err = strconv.ParseUint(Args[1], 10, 64)
 		if err != nil {
 			Usage()
 		}
 		InitRandom()
-		GeneratePad(os.Args[2], "")
-	} else if len(os.Args) == 5 {
-		Size, err = strconv.ParseUint(os.Args[1], 10, 64)
+		Queue = []*Job{{PadName: Args[2]}}
+		Generate()
+	} else if len(Args) == 5 {
+		Size, err = strconv.ParseUint(Args[1], 10, 64)
 		if err != nil {
 			Usage()
 		}
-		Number, err = strconv.ParseUint(os.Args[2], 10, 64)
+		Number, err = strconv.ParseUint(Args[2], 10, 64)
 		if err != nil {
 			Usage()
 		}
@@ -195,32 +564,32 @@ func main() {
 		Todo = make([][]string, 2)
 		Todo[0] = make([]string, 2)
 		Todo[1] = make([]string, 2)
-		Todo[0][0] = os.Args[3]
-		Todo[1][1] = os.Args[3]
-		Todo[0][1] = os.Args[4]
-		Todo[1][0] = os.Args[4]
+		Todo[0][0] = Args[3]
+		Todo[1][1] = Args[3]
+		Todo[0][1] = Args[4]
+		Todo[1][0] = Args[4]
 		DoTheWork()
-	} else if len(os.Args) == 4 {
-		Size, err = strconv.ParseUint(os.Args[1], 10, 64)
+	} else if len(Args) == 4 {
+		Size, err = strconv.ParseUint(Args[1], 10, 64)
 		if err != nil {
 			Usage()
 		}
-		Number, err = strconv.ParseUint(os.Args[2], 10, 64)
+		Number, err = strconv.ParseUint(Args[2], 10, 64)
 		if err != nil {
 			Usage()
 		}
 		InitRandom()
-		file, err := os.Open(os.Args[3])
-		FatalCheck(err)
+		file, err := os.Open(Args[3])
+		cli.FatalCheck(err)
 		defer file.Close()
 		csvReader := csv.NewReader(file)
 		csvReader.FieldsPerRecord = -1
 		Todo, err = csvReader.ReadAll()
-		FatalCheck(err)
+		cli.FatalCheck(err)
 		DoTheWork()
 	} else {
 		Usage()
 	}
-	fmt.Printf("genpads0: success.\n")
-	CleanExit(ExitSuccess)
+	fmt.Printf("%s: success.\n", cli.Name)
+	cli.Exit(cli.ExitSuccess)
 }
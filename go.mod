@@ -0,0 +1,3 @@
+module github.com/piotrcki/crypt0
+
+go 1.16
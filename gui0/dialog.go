@@ -0,0 +1,121 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package gui0
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The dialogs are plain terminal prompts: they work wherever a terminal does
+// and never go through a shell, so file names are taken as typed.
+
+var Input *bufio.Reader = bufio.NewReader(os.Stdin)
+var Output io.Writer = os.Stdout
+
+// ErrCanceled is returned when the user closes a dialog.
+var ErrCanceled = errors.New("canceled")
+
+func title(text string) {
+	fmt.Fprintf(Output, "\n== %s ==\n\n", text)
+}
+
+// readLine reads an answer, the end of the input cancels the dialog.
+func readLine() (string, error) {
+	line, err := Input.ReadString('\n')
+	if (err != nil) && ((err != io.EOF) || (line == "")) {
+		return "", ErrCanceled
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Prompt asks for a short answer, returned without the surrounding spaces.
+func Prompt(text string) (string, error) {
+	fmt.Fprintf(Output, "%s", text)
+	answer, err := readLine()
+	return strings.TrimSpace(answer), err
+}
+
+// AskPath asks for a file name. The quotes added by the terminals when a file
+// is dropped on them are removed, an empty answer cancels the dialog.
+func AskPath(caption, text string) (string, error) {
+	title(caption)
+	fmt.Fprintf(Output, "%s (empty to cancel): ", text)
+	name, err := readLine()
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimSpace(name)
+	if (len(name) > 1) && (name[0] == name[len(name)-1]) && ((name[0] == '\'') || (name[0] == '"')) {
+		name = name[1 : len(name)-1]
+	}
+	if name == "" {
+		return "", ErrCanceled
+	}
+	return name, nil
+}
+
+// Choose lets the user pick one of the items and returns its index, 0 or an
+// empty answer cancels the dialog.
+func Choose(caption, text string, items []string) (int, error) {
+	title(caption)
+	fmt.Fprintf(Output, "%s\n\n", text)
+	for i, item := range items {
+		fmt.Fprintf(Output, "%3d. %s\n", i+1, item)
+	}
+	for {
+		fmt.Fprintf(Output, "\nChoice (1-%d, empty to cancel): ", len(items))
+		answer, err := readLine()
+		if err != nil {
+			return -1, err
+		}
+		answer = strings.TrimSpace(answer)
+		if (answer == "") || (answer == "0") {
+			return -1, ErrCanceled
+		}
+		n, err := strconv.Atoi(answer)
+		if (err == nil) && (n >= 1) && (n <= len(items)) {
+			return n - 1, nil
+		}
+	}
+}
+
+// Confirm asks a yes or no question, no is the default.
+func Confirm(caption, text string) bool {
+	title(caption)
+	fmt.Fprintf(Output, "%s [y/N] ", text)
+	answer, err := readLine()
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return (answer == "y") || (answer == "yes")
+}
+
+// Message shows a result and waits for the user, so that a terminal opened for
+// the dialog does not close before it is read.
+func Message(caption, text string) {
+	title(caption)
+	fmt.Fprintf(Output, "%s\n\nPress Enter to close.", strings.TrimSpace(text))
+	readLine()
+	fmt.Fprintf(Output, "\n")
+}
@@ -0,0 +1,174 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+// Package gui0 is the `crypt0 encrypt-ui` and `crypt0 decrypt-ui` commands,
+// also run as encrypt0-gui and decrypt0-gui: dialogs that choose the peer,
+// the pad and the output, then run the encrypt or decrypt command.
+package gui0
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/piotrcki/crypt0"
+	"github.com/piotrcki/crypt0/cli"
+)
+
+var Args []string // the command line, Args[0] is the command name
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n\n")
+	fmt.Fprintf(os.Stderr, "%s [file]\n\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "file: the file to process, asked for when missing\n\n")
+	fmt.Fprintf(os.Stderr, "Return values:\n\n")
+	fmt.Fprintf(os.Stderr, "0: success\n")
+	fmt.Fprintf(os.Stderr, "1: pad too short or no valid pad\n")
+	fmt.Fprintf(os.Stderr, "9: other error or canceled\n")
+	cli.Exit(cli.ExitError)
+}
+
+// Check exits when a dialog was canceled.
+func Check(err error) {
+	if err != nil {
+		cli.Exit(cli.ExitError)
+	}
+}
+
+// Run runs a crypt0 command and returns its messages and its exit status.
+func Run(args ...string) (string, int) {
+	return cli.Spawn(nil, nil, args...)
+}
+
+// ParseArgs returns the file given on the command line, or asks for it.
+func ParseArgs(caption, text string) string {
+	if len(Args) > 2 {
+		Usage()
+	}
+	if len(Args) == 2 {
+		if _, err := os.Stat(Args[1]); err == nil {
+			return Args[1]
+		}
+	}
+	name, err := AskPath(caption, text)
+	Check(err)
+	return name
+}
+
+// ConfirmOverwrite asks before removing an existing output.
+func ConfirmOverwrite(name string) {
+	if _, err := os.Lstat(name); err != nil {
+		return
+	}
+	if !Confirm("Warning", fmt.Sprintf("%s exists. Do you want to overwrite it?", name)) {
+		cli.Exit(cli.ExitError)
+	}
+	if err := os.RemoveAll(name); err != nil {
+		Message("Error", err.Error())
+		cli.Exit(cli.ExitError)
+	}
+}
+
+// Peers returns the peers having a write pad for a plaintext of the given
+// size, with the rules of `encrypt --peer`.
+func Peers(size int64) []string {
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	if err != nil {
+		return nil
+	}
+	if _, err = os.Stat(crypt0.PeersDir()); err == nil {
+		if err = index.Refresh(crypt0.PeersDir(), func(string) {}); err == nil {
+			index.Save()
+		}
+	}
+	found := make(map[string]bool)
+	var ret []string
+	for _, r := range index.Pads {
+		if !found[r.Peer] && (index.SelectPad(r.Peer, size+crypt0.PadOverhead) != nil) {
+			found[r.Peer] = true
+			ret = append(ret, r.Peer)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Result shows the messages of a command and exits with its status.
+func Result(action, out string, status int) {
+	if status == cli.ExitSuccess {
+		Message(action+" succeeded", out)
+	} else {
+		Message(action+" failed", out)
+	}
+	cli.Exit(status)
+}
+
+// EncryptMain runs the encryption dialogs.
+func EncryptMain(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	input := ParseArgs("Select the file to encrypt", "File or directory to encrypt")
+	info, err := os.Stat(input)
+	if err != nil {
+		Result("Encryption", err.Error(), cli.ExitError)
+	}
+	output := filepath.Clean(input) + crypt0.CiphertextExt
+	if cli.Conf.Armor {
+		output += crypt0.ArmorExt
+	}
+	ConfirmOverwrite(output)
+	var size int64 = 0 // unknown for a directory
+	if !info.IsDir() {
+		size = info.Size()
+	}
+	if peers := Peers(size); len(peers) > 0 {
+		i, err := Choose("Select a peer", "Peer to encrypt the file for (or cancel to choose a pad)", peers)
+		if err == nil {
+			out, status := Run("encrypt", "--peer", peers[i], "--", input)
+			Result("Encryption", out, status)
+		}
+	}
+	pad, err := AskPath("Select the pad to use", "Pad (a .w.pad file)")
+	Check(err)
+	if !strings.HasSuffix(pad, crypt0.WritePadExt) {
+		Result("Encryption", fmt.Sprintf("%s is not a .w.pad file!", pad), cli.ExitError)
+	}
+	out, status := Run("encrypt", "--", input, pad)
+	Result("Encryption", out, status)
+}
+
+// DecryptMain runs the decryption dialogs.
+func DecryptMain(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	input := ParseArgs("Select the file to decrypt", "Ciphertext to decrypt (a .enc or .enc.asc file)")
+	output := strings.TrimSuffix(input, crypt0.ArmorExt)
+	if !strings.HasSuffix(output, crypt0.CiphertextExt) || (output == crypt0.CiphertextExt) {
+		Result("Decryption", fmt.Sprintf("%s is not a .enc or .enc.asc file!", input), cli.ExitError)
+	}
+	output = strings.TrimSuffix(output, crypt0.CiphertextExt)
+	ConfirmOverwrite(output)
+	if _, err := os.Stat(crypt0.PeersDir()); err == nil {
+		out, status := Run("decrypt", "--", input, crypt0.PeersDir())
+		if status != cli.ExitPad {
+			Result("Decryption", out, status)
+		}
+	}
+	pad, err := AskPath("Select the pad to use", "Pad (a .r.pad file) or directory containing it")
+	Check(err)
+	out, status := Run("decrypt", "--", input, pad)
+	Result("Decryption", out, status)
+}
@@ -0,0 +1,168 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const Magic string = "CRYPT0"
+const HeaderSize int = 24 // len(magic) + len(version) + len(flags) + len(padID) + len(sealed offset) = 6 + 1 + 1 + 8 + 8
+const PadIDSize int = 8
+const OffsetKeySize int64 = 32 // the last bytes of a pad, never consumed by a slice
+
+const FormatLegacy byte = 0  // headerless 0.x ciphertexts
+const FormatVersion byte = 3 // the pad offset is sealed with the offset key of the pad
+
+const FlagPadded byte = 0x01  // 0x00 padding hides the plaintext size
+const FlagArchive byte = 0x02 // the plaintext is a tar archive of a directory
+
+var ErrVersion = errors.New("unsupported ciphertext format version")
+
+// Header is the container header written in clear before the IV. It is
+// covered by the HMAC like the rest of the ciphertext.
+//
+// A legacy ciphertext starting by chance with the magic and a known version
+// (probability 2^-56) would be rejected as not authenticated.
+type Header struct {
+	Version byte
+	Flags   byte
+	PadID   []byte // nil for legacy ciphertexts
+	Offset  int64  // first byte of the pad slice used by the ciphertext, see OpenOffset
+	Sealed  []byte // the sealed offset, nil for legacy ciphertexts
+}
+
+// Len returns the size of the header in the ciphertext.
+func (h *Header) Len() int64 {
+	if h.Version == FormatLegacy {
+		return 0
+	}
+	return int64(HeaderSize)
+}
+
+func (h *Header) marshal() []byte {
+	if h.Version == FormatLegacy {
+		return nil
+	}
+	ret := make([]byte, 0, HeaderSize)
+	ret = append(ret, Magic...)
+	ret = append(ret, h.Version, h.Flags)
+	ret = append(ret, h.PadID...)
+	return append(ret, h.Sealed...)
+}
+
+// readHeader reads the header and the IV of a ciphertext.
+func readHeader(src io.Reader) (*Header, []byte, error) {
+	buff := make([]byte, IvSize) // up to the pad ID
+	if _, err := io.ReadFull(src, buff); err != nil {
+		return nil, nil, err
+	}
+	if string(buff[:len(Magic)]) != Magic {
+		// No header, these bytes are the IV
+		return &Header{Version: FormatLegacy}, buff[:IvSize], nil
+	}
+	h := &Header{Version: buff[6], Flags: buff[7], PadID: buff[8:]}
+	switch h.Version {
+	case FormatVersion:
+		h.Sealed = make([]byte, 8)
+		if _, err := io.ReadFull(src, h.Sealed); err != nil {
+			return nil, nil, err
+		}
+	case FormatBundle:
+		return nil, nil, ErrBundle
+	default:
+		return nil, nil, ErrVersion
+	}
+	iv := make([]byte, IvSize)
+	if _, err := io.ReadFull(src, iv); err != nil {
+		return nil, nil, err
+	}
+	return h, iv, nil
+}
+
+// ParseHeader reads the header at the beginning of a ciphertext. The offset is
+// only known once opened with the pad (see OpenOffset).
+func ParseHeader(src io.Reader) (*Header, error) {
+	h, _, err := readHeader(src)
+	return h, err
+}
+
+// ReadOffsetKey reads the offset key of a pad: its last OffsetKeySize bytes,
+// which are never part of a slice.
+func ReadOffsetKey(pad io.ReaderAt, padSize int64) ([]byte, error) {
+	if padSize < OffsetKeySize {
+		return nil, ErrPadTooShort
+	}
+	key := make([]byte, OffsetKeySize)
+	if _, err := pad.ReadAt(key, padSize-OffsetKeySize); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// offsetMask derives the mask hiding the offset of the slice having the given
+// pad ID: every slice has its own mask, so sealed offsets cannot be linked.
+func offsetMask(key, padID []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write([]byte("crypt0 offset"))
+	mac.Write(padID)
+	return mac.Sum(nil)[:8]
+}
+
+// SealOffset returns the offset of a slice as written in a header.
+func SealOffset(key, padID []byte, offset int64) []byte {
+	sealed := make([]byte, 8)
+	binary.BigEndian.PutUint64(sealed, uint64(offset))
+	xorBytes(sealed, offsetMask(key, padID))
+	return sealed
+}
+
+// OpenOffset recovers the offset of a header with the offset key of a pad.
+// With the key of another pad, the offset is random and the slice found there
+// does not have the pad ID of the header. Legacy headers are left as is.
+func (h *Header) OpenOffset(key []byte) error {
+	if h.Version == FormatLegacy {
+		return nil
+	}
+	offset := make([]byte, 8)
+	copy(offset, h.Sealed)
+	xorBytes(offset, offsetMask(key, h.PadID))
+	h.Offset = int64(binary.BigEndian.Uint64(offset))
+	if h.Offset < 0 {
+		return ErrAuthentication
+	}
+	return nil
+}
+
+// padID derives the identifier of a pad slice from its HMAC key.
+func padID(hmacKey []byte) []byte {
+	sum := sha512.Sum512(append([]byte("crypt0 pad id"), hmacKey...))
+	return sum[:PadIDSize]
+}
+
+// ReadPadID reads the beginning of a pad slice and returns its identifier.
+func ReadPadID(pad io.Reader) ([]byte, error) {
+	k, err := readKeys(pad)
+	if err != nil {
+		return nil, err
+	}
+	return padID(k.hmacKey), nil
+}
@@ -0,0 +1,90 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// TestSealedOffset checks that a header does not show the offset of its slice
+// and that only the offset key of the pad recovers it.
+func TestSealedOffset(t *testing.T) {
+	pad := random(t, 8192)
+	key, err := ReadOffsetKey(bytes.NewReader(pad), int64(len(pad)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("sealed offset")
+	for _, offset := range []int64{0, 1024, 4096} {
+		var out bytes.Buffer
+		opts := &Options{Size: int64(len(plaintext)), Offset: offset, OffsetKey: key}
+		enc, err := NewEncrypter(bytes.NewReader(pad[offset:]), &out, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = enc.Write(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		if err = enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		h, err := ParseHeader(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Version != FormatVersion {
+			t.Fatalf("format %d written", h.Version)
+		}
+		clear := make([]byte, 8)
+		binary.BigEndian.PutUint64(clear, uint64(offset))
+		if bytes.Equal(h.Sealed, clear) {
+			t.Errorf("offset %d written in clear", offset)
+		}
+		if err = h.OpenOffset(random(t, int(OffsetKeySize))); (err == nil) && (h.Offset == offset) {
+			t.Errorf("offset %d opened with another key", offset)
+		}
+		if err = h.OpenOffset(key); (err != nil) || (h.Offset != offset) {
+			t.Fatalf("offset %d opened as %d: %v", offset, h.Offset, err)
+		}
+		dec, err := NewDecrypter(bytes.NewReader(pad[h.Offset:]), bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := ioutil.ReadAll(dec)
+		if (err != nil) || !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+	}
+	if _, err = NewEncrypter(bytes.NewReader(pad), ioutil.Discard, &Options{}); err == nil {
+		t.Error("encrypted without offset key")
+	}
+}
+
+// TestClearOffsetRejected checks that the headers of the formats that showed
+// the pad offset in clear are not read.
+func TestClearOffsetRejected(t *testing.T) {
+	for _, version := range []byte{1, 2} {
+		buff := append([]byte(Magic), version, 0)
+		buff = append(buff, random(t, HeaderSize-len(buff)+IvSize)...)
+		if _, err := ParseHeader(bytes.NewReader(buff)); err != ErrVersion {
+			t.Errorf("format %d: %v", version, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// Health tests of an entropy source, after NIST SP 800-90B section 4.4. The
+// source is read as 8-bit samples claimed to carry at least the given
+// min-entropy each. The false positive probability of each test is about
+// 2^-HealthAlpha per sample (or per window), low enough to generate terabytes
+// of pads without a false alarm.
+const HealthAlpha float64 = 40
+const HealthWindow int = 512    // samples of an adaptive proportion test window
+const HealthBlock int = 64      // bytes compared by the repeated block test
+const SourceEntropy float64 = 1 // min-entropy claimed per byte of the sources
+
+// HealthError tells which source failed which test.
+type HealthError struct {
+	Source string
+	Test   string
+	Detail string
+}
+
+func (e *HealthError) Error() string {
+	return fmt.Sprintf("entropy source `%s` failed the %s test: %s", e.Source, e.Test, e.Detail)
+}
+
+// HealthTest runs the continuous health tests on the output of a source:
+//
+// * the repetition count test fails when a sample is repeated RCTCutoff times
+// in a row, a stuck source;
+//
+// * the adaptive proportion test fails when the first sample of a window of
+// HealthWindow samples appears APTCutoff times in it, a biased source;
+//
+// * the repeated block test fails when a block of HealthBlock bytes is equal to
+// the previous one, a source replaying its output.
+type HealthTest struct {
+	Source    string
+	RCTCutoff int
+	APTCutoff int
+
+	last     byte
+	repeated int
+	first    byte
+	seen     int
+	count    int
+	block    []byte
+	previous []byte
+	started  bool
+}
+
+// NewHealthTest returns the health tests of a source whose samples carry at
+// least the given min-entropy in bits (between 0 and 8 excluded).
+func NewHealthTest(source string, entropy float64) *HealthTest {
+	return &HealthTest{
+		Source:    source,
+		RCTCutoff: 1 + int(math.Ceil(HealthAlpha/entropy)),
+		APTCutoff: aptCutoff(math.Pow(2, -entropy)),
+		block:     make([]byte, 0, HealthBlock),
+	}
+}
+
+// aptCutoff returns the smallest count of the first sample of a window, itself
+// included, that has a probability below 2^-HealthAlpha when every sample has
+// the probability p: the critical value of a binomial distribution.
+func aptCutoff(p float64) int {
+	n := HealthWindow - 1
+	lg := func(x int) float64 {
+		v, _ := math.Lgamma(float64(x + 1))
+		return v
+	}
+	tail := 0.0
+	for k := n; k >= 0; k-- {
+		tail += math.Exp(lg(n) - lg(k) - lg(n-k) + float64(k)*math.Log(p) + float64(n-k)*math.Log1p(-p))
+		if tail > math.Pow(2, -HealthAlpha) {
+			return k + 2 // k+1 repetitions in the window are too likely, the first sample is counted
+		}
+	}
+	return 1
+}
+
+// Check runs the tests on the next bytes of the source.
+func (t *HealthTest) Check(p []byte) error {
+	for len(p) > 0 {
+		n := HealthBlock - len(t.block)
+		if n > len(p) {
+			n = len(p)
+		}
+		if err := t.samples(p[:n]); err != nil {
+			return err
+		}
+		t.block = append(t.block, p[:n]...)
+		p = p[n:]
+		if len(t.block) < HealthBlock {
+			continue
+		}
+		if bytes.Equal(t.block, t.previous) {
+			return &HealthError{t.Source, "repeated block",
+				fmt.Sprintf("a block of %d bytes was repeated", HealthBlock)}
+		}
+		t.block, t.previous = t.previous[:0], t.block
+		if t.block == nil {
+			t.block = make([]byte, 0, HealthBlock)
+		}
+	}
+	return nil
+}
+
+// samples runs the repetition count and adaptive proportion tests.
+func (t *HealthTest) samples(p []byte) error {
+	for _, b := range p {
+		if t.started && (b == t.last) {
+			t.repeated++
+			if t.repeated >= t.RCTCutoff {
+				return &HealthError{t.Source, "repetition count",
+					fmt.Sprintf("the byte 0x%02x was repeated %d times in a row", b, t.repeated)}
+			}
+		} else {
+			t.last, t.repeated, t.started = b, 1, true
+		}
+		if t.seen == 0 {
+			t.first, t.count = b, 1
+		} else if b == t.first {
+			t.count++
+			if t.count >= t.APTCutoff {
+				return &HealthError{t.Source, "adaptive proportion",
+					fmt.Sprintf("the byte 0x%02x appeared %d times in a window of %d bytes", b, t.count, HealthWindow)}
+			}
+		}
+		if t.seen++; t.seen == HealthWindow {
+			t.seen = 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// healthFailure feeds a source to new health tests and returns the test that
+// failed, or "" if none.
+func healthFailure(t *testing.T, src []byte) string {
+	h := NewHealthTest("test", SourceEntropy)
+	err := h.Check(src)
+	if err == nil {
+		return ""
+	}
+	herr, ok := err.(*HealthError)
+	if !ok {
+		t.Fatalf("%T returned: %v", err, err)
+	}
+	return herr.Test
+}
+
+// TestHealthStuck checks that a stuck source trips the repetition count test.
+func TestHealthStuck(t *testing.T) {
+	for _, b := range []byte{0x00, 0x5a, 0xff} {
+		if test := healthFailure(t, bytes.Repeat([]byte{b}, 4096)); test != "repetition count" {
+			t.Errorf("stuck at 0x%02x: %q failed", b, test)
+		}
+	}
+}
+
+// TestHealthBiased checks that a biased source trips the adaptive proportion
+// test: three bytes out of four are 0x00, but never in long runs.
+func TestHealthBiased(t *testing.T) {
+	src := make([]byte, 8*HealthWindow)
+	noise := random(t, len(src))
+	for i := 3; i < len(src); i += 4 {
+		src[i] = noise[i] | 0x01
+	}
+	if test := healthFailure(t, src); test != "adaptive proportion" {
+		t.Fatalf("%q failed", test)
+	}
+}
+
+// TestHealthRandom checks that crypto/rand passes, in chunks of every size.
+func TestHealthRandom(t *testing.T) {
+	h := NewHealthTest("crypto/rand", SourceEntropy)
+	buffer := make([]byte, 4096)
+	for i := 0; i < 1024; i++ {
+		chunk := buffer[:1+(i*61)%len(buffer)]
+		if _, err := rand.Read(chunk); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Check(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
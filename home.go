@@ -0,0 +1,58 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Home returns the crypt0 directory: $CRYPT0_HOME, ~/.crypt0 by default.
+func Home() string {
+	home := os.Getenv("CRYPT0_HOME")
+	if home != "" {
+		return home
+	}
+	user, err := os.UserHomeDir()
+	if err != nil {
+		return ".crypt0"
+	}
+	return filepath.Join(user, ".crypt0")
+}
+
+// PeersDir returns the directory holding one directory of pads per peer.
+func PeersDir() string {
+	return filepath.Join(Home(), "peers")
+}
+
+// ListPeers returns the names of the peers of a directory like PeersDir: its
+// subdirectories, except the hidden ones.
+func ListPeers(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, info := range infos {
+		if info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			ret = append(ret, info.Name())
+		}
+	}
+	return ret, nil
+}
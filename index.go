@@ -0,0 +1,421 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const IndexVersion int = 2
+
+// Pad directions.
+const DirectionWrite string = "w"
+const DirectionRead string = "r"
+
+// States of a pad in the index.
+const PadFresh string = "fresh"         // no byte consumed yet
+const PadInUse string = "in-use"        // some slices consumed
+const PadExhausted string = "exhausted" // no message can be sent or received anymore
+const PadPending string = "pending"     // an unfinished slice, see `pads0 recover`
+const PadUnknown string = "unknown"     // a read pad indexed from its file, its slices may have been consumed
+
+var ErrNotPad = errors.New("not a pad (.w.pad, .r.pad or .x.pad)")
+
+// Index is the pad index of CRYPT0_HOME, a single file atomically replaced on
+// every update. It describes every pad and, for every read pad, records the
+// pad IDs of the slices expected in the next ciphertexts, so that decrypt0
+// finds the pad of a ciphertext without trying every pad. It is a cache that
+// `pads0 rebuild` recreates from the files: losing it, or an update lost to a
+// concurrent run, only makes the commands slower.
+type Index struct {
+	Name    string       `json:"-"`
+	Version int          `json:"version"`
+	Pads    []*PadRecord `json:"pads"`
+	ids     map[string]hintRef
+}
+
+// hintRef locates a hint in the index, see Lookup.
+type hintRef struct {
+	r *PadRecord
+	h *Hint
+}
+
+// PadRecord is the entry of a pad in the index.
+type PadRecord struct {
+	Path      string    `json:"path"`      // absolute
+	ID        string    `json:"id"`        // the file name without extension
+	Peer      string    `json:"peer"`      // the name of the directory of the pad
+	Direction string    `json:"direction"` // DirectionWrite or DirectionRead
+	Size      int64     `json:"size"`
+	Remaining int64     `json:"remaining"` // bytes after the last slice consumed, the offset key excluded
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used"` // zero if never used by this host
+	State     string    `json:"state"`
+	Used      int       `json:"used,omitempty"`   // used slices of a write pad
+	Burned    int       `json:"burned,omitempty"` // burned slices of a write pad
+	Hints     []*Hint   `json:"hints,omitempty"`
+}
+
+// Hint is the pad ID of a slice expected in a future ciphertext.
+type Hint struct {
+	ID     string `json:"id"` // hexadecimal
+	Offset int64  `json:"offset"`
+}
+
+// IndexName returns the name of the pad index of CRYPT0_HOME.
+func IndexName() string {
+	return filepath.Join(Home(), "index.json")
+}
+
+// OpenIndex loads a pad index, a missing index is empty.
+func OpenIndex(name string) (*Index, error) {
+	ix := &Index{Name: name, Version: IndexVersion}
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, ix); err != nil {
+		return nil, err
+	}
+	if ix.Version != IndexVersion {
+		return nil, ErrVersion
+	}
+	return ix, nil
+}
+
+// Save atomically writes the index.
+func (ix *Index) Save() error {
+	data, err := json.MarshalIndent(ix, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(ix.Name), 0700); err != nil {
+		return err
+	}
+	return WriteFileAtomic(ix.Name, append(data, '\n'), 0600)
+}
+
+// Pad returns the record of a pad, nil if the pad is not indexed.
+func (ix *Index) Pad(path string) *PadRecord {
+	for _, r := range ix.Pads {
+		if r.Path == path {
+			return r
+		}
+	}
+	return nil
+}
+
+// Add returns the record of a pad, created if needed.
+func (ix *Index) Add(path string) *PadRecord {
+	r := ix.Pad(path)
+	if r == nil {
+		r = &PadRecord{Path: path}
+		ix.Pads = append(ix.Pads, r)
+	}
+	ix.ids = nil
+	return r
+}
+
+// Remove forgets a pad.
+func (ix *Index) Remove(path string) {
+	ix.ids = nil
+	for i, r := range ix.Pads {
+		if r.Path == path {
+			ix.Pads = append(ix.Pads[:i], ix.Pads[i+1:]...)
+			return
+		}
+	}
+}
+
+// Update refreshes the record of a pad from its files. The pad is added if
+// needed, with the pad ID of its first slice when it is a read pad, and it is
+// forgotten if it no longer exists (the record is then nil). Unlike a write
+// pad, a read pad has no ledger: the slices consumed are only known from its
+// record, a read pad added here is unknown until a slice of it is used (see
+// Fresh for the pads just created).
+func (ix *Index) Update(path string) (*PadRecord, error) {
+	ix.ids = nil
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		ix.Remove(path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	var ext string
+	for _, ext = range []string{WritePadExt, ReadPadExt, UsedPadExt} {
+		if strings.HasSuffix(name, ext) {
+			break
+		}
+	}
+	if !strings.HasSuffix(name, ext) {
+		return nil, ErrNotPad
+	}
+	r := ix.Pad(path)
+	if r == nil {
+		r = &PadRecord{Path: path}
+		if ext == ReadPadExt {
+			if err = r.Expect(0); err != nil {
+				return nil, err
+			}
+			r.State = PadUnknown
+		}
+		ix.Pads = append(ix.Pads, r)
+	}
+	if r.Created.IsZero() {
+		r.Created = padCreated(name, info)
+	}
+	r.ID = strings.TrimSuffix(name, ext)
+	r.Peer = filepath.Base(filepath.Dir(path))
+	r.Size = info.Size()
+	if ext == ReadPadExt {
+		r.Direction = DirectionRead
+		r.Remaining = 0
+		for _, h := range r.Hints {
+			if (Sliceable(r.Size) - h.Offset) > r.Remaining {
+				r.Remaining = Sliceable(r.Size) - h.Offset
+			}
+		}
+		switch {
+		case r.Remaining == 0:
+			r.State = PadExhausted
+		case r.Remaining != Sliceable(r.Size):
+			r.State = PadInUse
+		case r.State != PadUnknown:
+			r.State = PadFresh
+		}
+		return r, nil
+	}
+	r.Direction = DirectionWrite
+	l, err := OpenLedger(path)
+	if err != nil {
+		return nil, err
+	}
+	r.Remaining = Sliceable(r.Size) - l.Next()
+	if r.Remaining < 0 {
+		r.Remaining = 0
+	}
+	r.Used, r.Burned = 0, 0
+	for _, e := range l.Entries {
+		switch e.State {
+		case StateUsed:
+			r.Used++
+		case StateBurned:
+			r.Burned++
+		}
+	}
+	switch {
+	case len(l.Pending()) > 0:
+		r.State = PadPending
+	case (ext == UsedPadExt) || l.Exhausted(r.Size):
+		r.State = PadExhausted
+	case r.Remaining == Sliceable(r.Size):
+		r.State = PadFresh
+	default:
+		r.State = PadInUse
+	}
+	return r, nil
+}
+
+// Fresh indexes pads just created, that are known to be unused, replacing
+// their former records.
+func (ix *Index) Fresh(paths ...string) error {
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		ix.Remove(path)
+		r, err := ix.Update(path)
+		if err != nil {
+			return err
+		}
+		if (r != nil) && (r.State == PadUnknown) {
+			r.State = PadFresh
+		}
+	}
+	return nil
+}
+
+// FindPads returns the absolute names of the pads found in dir, recursively.
+func FindPads(dir string) ([]string, error) {
+	var ret []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && (strings.HasSuffix(path, ReadPadExt) ||
+			strings.HasSuffix(path, WritePadExt) || strings.HasSuffix(path, UsedPadExt)) {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			ret = append(ret, abs)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// Refresh forgets the pads that no longer exist and updates the records of the
+// pads found in dir. The changes are reported through the log function.
+func (ix *Index) Refresh(dir string, log func(string)) error {
+	var gone []string
+	for _, r := range ix.Pads {
+		if _, err := os.Stat(r.Path); os.IsNotExist(err) {
+			gone = append(gone, r.Path)
+		}
+	}
+	for _, padName := range gone {
+		ix.Remove(padName)
+		log(fmt.Sprintf("`%s` forgotten", padName))
+	}
+	pads, err := FindPads(dir)
+	if err != nil {
+		return err
+	}
+	for _, padName := range pads {
+		known := ix.Pad(padName) != nil
+		if _, err = ix.Update(padName); err != nil {
+			return err
+		}
+		if !known {
+			log(fmt.Sprintf("`%s` indexed", padName))
+		}
+	}
+	return nil
+}
+
+// Within returns the records of the pads found in dir, recursively.
+func (ix *Index) Within(dir string) ([]*PadRecord, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*PadRecord
+	for _, r := range ix.Pads {
+		if strings.HasPrefix(r.Path, root+string(filepath.Separator)) {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+// padCreated returns the creation time of a pad: genpads0 used to name the
+// pads after the hexadecimal Unix time in nanoseconds, the modification time of
+// the file is used for other names, random ones included.
+func padCreated(name string, info os.FileInfo) time.Time {
+	n, err := strconv.ParseInt(strings.SplitN(name, ".", 2)[0], 16, 64)
+	if (err == nil) && (n > time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()) &&
+		(n < time.Now().Add(24*time.Hour).UnixNano()) {
+		return time.Unix(0, n).UTC()
+	}
+	return info.ModTime().UTC()
+}
+
+// SelectPad returns the write pad of a peer that is the best fit for a slice
+// of the given length: the one with the fewest remaining bytes, the oldest one
+// on a tie. It returns nil if no pad can give such a slice.
+func (ix *Index) SelectPad(peer string, length int64) *PadRecord {
+	var best *PadRecord
+	for _, r := range ix.Pads {
+		if (r.Peer != peer) || (r.Direction != DirectionWrite) || (r.Remaining < length) ||
+			((r.State != PadFresh) && (r.State != PadInUse)) {
+			continue
+		}
+		if _, err := os.Stat(r.Path); err != nil {
+			continue
+		}
+		if (best == nil) || (r.Remaining < best.Remaining) ||
+			((r.Remaining == best.Remaining) && r.Created.Before(best.Created)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// Lookup returns the pad and the slice having the given pad ID, nil if the ID
+// is not expected. The hints are indexed by pad ID on the first lookup, the
+// table is rebuilt after Add, Remove or Update: the hints changed directly
+// through a record are only seen once the record is updated.
+func (ix *Index) Lookup(id []byte) (*PadRecord, *Hint) {
+	if ix.ids == nil {
+		ix.ids = make(map[string]hintRef)
+		for _, r := range ix.Pads {
+			for _, h := range r.Hints {
+				ix.ids[h.ID] = hintRef{r, h}
+			}
+		}
+	}
+	ref := ix.ids[hex.EncodeToString(id)]
+	return ref.r, ref.h
+}
+
+// Expect reads the pad ID of the slice starting at offset and records it. It
+// has no effect if the pad has no room for a slice there.
+func (r *PadRecord) Expect(offset int64) error {
+	for _, h := range r.Hints {
+		if h.Offset == offset {
+			return nil
+		}
+	}
+	pad, err := os.Open(r.Path)
+	if err != nil {
+		return err
+	}
+	defer pad.Close()
+	info, err := pad.Stat()
+	if err != nil {
+		return err
+	}
+	if (Sliceable(info.Size()) - offset) < PadOverhead {
+		return nil
+	}
+	if _, err = pad.Seek(offset, 0); err != nil {
+		return err
+	}
+	id, err := ReadPadID(pad)
+	if err != nil {
+		return err
+	}
+	r.Hints = append(r.Hints, &Hint{hex.EncodeToString(id), offset})
+	return nil
+}
+
+// Forget removes the hint of the slice starting at offset.
+func (r *PadRecord) Forget(offset int64) {
+	for i, h := range r.Hints {
+		if h.Offset == offset {
+			r.Hints = append(r.Hints[:i], r.Hints[i+1:]...)
+			return
+		}
+	}
+}
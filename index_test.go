@@ -0,0 +1,76 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestIndexReadPads checks that a read pad indexed from its file is unknown
+// until a slice of it is used, unless it was just created.
+func TestIndexReadPads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt0-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ix := &Index{Name: filepath.Join(dir, "index.json"), Version: IndexVersion}
+	state := func(path string) string {
+		r, err := ix.Update(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.State
+	}
+	var pads []string
+	for _, name := range []string{"0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210"} {
+		path := filepath.Join(dir, name+ReadPadExt)
+		if err = ioutil.WriteFile(path, random(t, 1<<16), 0600); err != nil {
+			t.Fatal(err)
+		}
+		pads = append(pads, path)
+	}
+	for i := 0; i < 2; i++ {
+		if s := state(pads[0]); s != PadUnknown {
+			t.Fatalf("read pad indexed from its file is %s", s)
+		}
+	}
+	r := ix.Pad(pads[0])
+	r.Forget(0)
+	if err = r.Expect(4096); err != nil {
+		t.Fatal(err)
+	}
+	if s := state(pads[0]); s != PadInUse {
+		t.Fatalf("read pad used once is %s", s)
+	}
+	if err = ix.Fresh(pads[1]); err != nil {
+		t.Fatal(err)
+	}
+	if s := state(pads[1]); s != PadFresh {
+		t.Fatalf("read pad just created is %s", s)
+	}
+	writePad := filepath.Join(dir, "00112233445566778899aabbccddeeff"+WritePadExt)
+	if err = ioutil.WriteFile(writePad, random(t, 1<<16), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if s := state(writePad); s != PadFresh {
+		t.Fatalf("write pad without ledger is %s", s)
+	}
+}
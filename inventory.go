@@ -0,0 +1,108 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Stock sums up the pads of a peer in one direction.
+type Stock struct {
+	Peer      string   `json:"peer"`
+	Direction string   `json:"direction"`
+	Unused    int      `json:"unused"` // fresh pads
+	InUse     int      `json:"in_use"` // pads in use, or read pads whose use is unknown
+	Exhausted int      `json:"exhausted"`
+	Pending   int      `json:"pending"` // pads waiting for `pads0 recover`
+	Bytes     int64    `json:"bytes"`   // remaining bytes of the fresh and in-use pads
+	Largest   int64    `json:"largest"` // largest plaintext a single pad can still encrypt
+	Used      int      `json:"used"`    // used slices of the write pads
+	Burned    int      `json:"burned"`  // burned slices of the write pads
+	Low       []string `json:"low,omitempty"`
+}
+
+// Thresholds below which a stock is running low, zero disables a threshold.
+type Thresholds struct {
+	Pads    int   // fresh pads
+	Bytes   int64 // remaining bytes
+	Message int64 // largest plaintext
+}
+
+// Inventory returns the stocks of the pads of the records, sorted by peer and
+// direction. Both directions of the given peers are listed even without pad.
+func Inventory(records []*PadRecord, peers []string) []*Stock {
+	stocks := make(map[string]*Stock)
+	stock := func(peer, direction string) *Stock {
+		key := peer + "/" + direction
+		if stocks[key] == nil {
+			stocks[key] = &Stock{Peer: peer, Direction: direction}
+		}
+		return stocks[key]
+	}
+	for _, peer := range peers {
+		stock(peer, DirectionWrite)
+		stock(peer, DirectionRead)
+	}
+	for _, r := range records {
+		s := stock(r.Peer, r.Direction)
+		s.Used += r.Used
+		s.Burned += r.Burned
+		switch r.State {
+		case PadFresh:
+			s.Unused++
+		case PadInUse, PadUnknown:
+			s.InUse++
+		case PadExhausted:
+			s.Exhausted++
+			continue
+		case PadPending:
+			s.Pending++
+			continue
+		}
+		s.Bytes += r.Remaining
+		if (r.Remaining - PadOverhead) > s.Largest {
+			s.Largest = r.Remaining - PadOverhead
+		}
+	}
+	var ret []*Stock
+	for _, s := range stocks {
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Peer != ret[j].Peer {
+			return ret[i].Peer < ret[j].Peer
+		}
+		return ret[i].Direction > ret[j].Direction // write first
+	})
+	return ret
+}
+
+// Check records in Low why the stock is running low and tells if it is.
+func (s *Stock) Check(t Thresholds) bool {
+	s.Low = nil
+	if s.Unused < t.Pads {
+		s.Low = append(s.Low, fmt.Sprintf("%d unused pads, %d wanted", s.Unused, t.Pads))
+	}
+	if s.Bytes < t.Bytes {
+		s.Low = append(s.Low, fmt.Sprintf("%d bytes left, %d wanted", s.Bytes, t.Bytes))
+	}
+	if s.Largest < t.Message {
+		s.Low = append(s.Low, fmt.Sprintf("largest message of %d bytes, %d wanted", s.Largest, t.Message))
+	}
+	return len(s.Low) > 0
+}
@@ -0,0 +1,271 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const LedgerExt string = ".ledger"
+const LockExt string = ".lock"
+const MinSlice int64 = 1024 // slices are rounded up to powers of two from here
+
+// States of a pad slice. A slice is reserved before being used, then it is
+// either used (the ciphertext is complete), released (no ciphertext byte was
+// ever written, the slice may be handed out again) or burned (something may
+// have been written, the slice is lost).
+const StateReserved string = "reserved"
+const StateUsed string = "used"
+const StateReleased string = "released"
+const StateBurned string = "burned"
+
+var ErrLocked = errors.New("the pad is locked: it is in use or a crash happened (see `pads0 recover`)")
+
+// Range is a slice of a pad, in bytes.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// End returns the first byte after the range.
+func (r Range) End() int64 {
+	return r.Offset + r.Length
+}
+
+// Entry is the state of a pad slice.
+type Entry struct {
+	Range
+	State  string
+	Output string // absolute name of the ciphertext, "-" for the standard output
+}
+
+// Ledger is the write-ahead journal of the slices consumed from a pad. It is
+// stored next to the pad (see LedgerName) and must never be lost while the
+// pad is in use: without it, consumed slices would be used again. Every state
+// change is durably written before the corresponding action is taken.
+type Ledger struct {
+	Name    string
+	Entries []*Entry
+}
+
+// LedgerName returns the name of the ledger of a pad.
+func LedgerName(padName string) string {
+	return padName + LedgerExt
+}
+
+// OpenLedger loads the ledger of a pad. A pad without ledger has no consumed
+// slice. A ledger that is truncated or holds an invalid entry is an error:
+// guessing what it said could hand out a consumed slice again.
+func OpenLedger(padName string) (*Ledger, error) {
+	l := &Ledger{Name: LedgerName(padName)}
+	data, err := ioutil.ReadFile(l.Name)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if (len(data) > 0) && (data[len(data)-1] != '\n') {
+		return nil, fmt.Errorf("%s: truncated", l.Name)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e := &Entry{State: StateUsed}
+		n, _ := fmt.Sscanf(line, "%d %d %s %q", &e.Offset, &e.Length, &e.State, &e.Output)
+		if (n < 2) || (n == 3) {
+			return nil, fmt.Errorf("%s: malformed line `%s`", l.Name, line)
+		}
+		if (e.Offset < 0) || (e.Length <= 0) {
+			return nil, fmt.Errorf("%s: invalid slice in `%s`", l.Name, line)
+		}
+		switch e.State {
+		case StateReserved, StateUsed, StateReleased, StateBurned:
+		default:
+			return nil, fmt.Errorf("%s: unknown state in `%s`", l.Name, line)
+		}
+		l.Entries = append(l.Entries, e)
+	}
+	return l, scanner.Err()
+}
+
+// Next returns the first byte that was never handed out.
+func (l *Ledger) Next() int64 {
+	var next int64 = 0
+	for _, e := range l.Entries {
+		if (e.State != StateReleased) && (e.End() > next) {
+			next = e.End()
+		}
+	}
+	return next
+}
+
+// Sliceable returns the number of bytes of a pad that slices may consume: the
+// offset key at its end is kept until the pad is destroyed.
+func Sliceable(padSize int64) int64 {
+	return padSize - OffsetKeySize
+}
+
+// Pending returns the reserved slices. Outside of an encryption holding the
+// lock of the pad, they are the remains of a crash.
+func (l *Ledger) Pending() []*Entry {
+	var ret []*Entry
+	for _, e := range l.Entries {
+		if e.State == StateReserved {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// Reserve records a new slice of the given length for the given output. The
+// ledger is durably written before Reserve returns, so the slice is never
+// handed out again, even after a crash.
+func (l *Ledger) Reserve(length, padSize int64, output string) (*Entry, error) {
+	e := &Entry{Range{l.Next(), length}, StateReserved, output}
+	if (length < PadOverhead) || (e.End() > Sliceable(padSize)) {
+		return nil, ErrPadTooShort
+	}
+	if output != "-" {
+		var err error
+		if e.Output, err = filepath.Abs(output); err != nil {
+			return nil, err
+		}
+	}
+	l.Entries = append(l.Entries, e)
+	if err := l.Save(); err != nil {
+		l.Entries = l.Entries[:len(l.Entries)-1]
+		return nil, err
+	}
+	return e, nil
+}
+
+// Set changes the state of a slice and durably writes the ledger.
+func (l *Ledger) Set(e *Entry, state string) error {
+	old := e.State
+	e.State = state
+	if err := l.Save(); err != nil {
+		e.State = old
+		return err
+	}
+	return nil
+}
+
+// Save atomically writes the ledger.
+func (l *Ledger) Save() error {
+	var buff bytes.Buffer
+	fmt.Fprintf(&buff, "# crypt0 pad ledger: offset length state output\n")
+	for _, e := range l.Entries {
+		fmt.Fprintf(&buff, "%d %d %s %q\n", e.Offset, e.Length, e.State, e.Output)
+	}
+	return WriteFileAtomic(l.Name, buff.Bytes(), 0600)
+}
+
+// Rename moves the ledger, it has no effect if the ledger was never saved.
+func (l *Ledger) Rename(padName string) error {
+	name := LedgerName(padName)
+	err := os.Rename(l.Name, name)
+	if (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	l.Name = name
+	return nil
+}
+
+// LockPad prevents concurrent encryptions with the same pad. The lock is a
+// file next to the pad that is left behind by a crash.
+func LockPad(padName string) error {
+	f, err := os.OpenFile(padName+LockExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return ErrLocked
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	return f.Close()
+}
+
+// UnlockPad removes the lock of a pad.
+func UnlockPad(padName string) error {
+	return os.Remove(padName + LockExt)
+}
+
+// SlicePadding returns the padding that rounds the slice consumed by a
+// plaintext of the given size up to the next power of two (MinSlice at
+// least), without exceeding the available bytes of the pad.
+func SlicePadding(available, size int64) (int64, error) {
+	slice := size + PadOverhead
+	if slice > available {
+		return 0, ErrPadTooShort
+	}
+	bucket := MinSlice
+	for bucket < slice {
+		bucket *= 2
+	}
+	if bucket > available {
+		bucket = available
+	}
+	return bucket - slice, nil
+}
+
+// WriteFileAtomic replaces a file by a new content: the data is written to a
+// temporary file, synced, then renamed over the old file and the directory is
+// synced. Readers see either the old or the new content.
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return SyncDir(filepath.Dir(name))
+}
+
+// SyncDir flushes a directory so that renames and removals in it are durable.
+// Systems that cannot sync directories are silently ignored.
+func SyncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	d.Sync()
+	return nil
+}
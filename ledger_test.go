@@ -0,0 +1,146 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPad creates a pad of the given size in a temporary directory and
+// returns its name and the directory, to remove.
+func testPad(t *testing.T, size int) (string, string) {
+	dir, err := ioutil.TempDir("", "crypt0-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	padName := filepath.Join(dir, "5f0e2b8c6d1a4e97b3c2a18d9e7f6051"+WritePadExt)
+	if err = ioutil.WriteFile(padName, random(t, size), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return padName, dir
+}
+
+// TestLedgerNoOverlap checks that the slices handed out by Reserve never
+// overlap and stay out of the offset key, whatever happens to the previous
+// ones, also once the ledger is loaded again.
+func TestLedgerNoOverlap(t *testing.T) {
+	padName, dir := testPad(t, 1<<16)
+	defer os.RemoveAll(dir)
+	const padSize = 1 << 16
+	l, err := OpenLedger(padName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prng := rand.New(rand.NewSource(1))
+	for i := 0; ; i++ {
+		e, err := l.Reserve(PadOverhead+prng.Int63n(2048), padSize, filepath.Join(dir, "out.enc"))
+		if err == ErrPadTooShort {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.End() > Sliceable(padSize) {
+			t.Fatalf("slice %d to %d overlaps the offset key", e.Offset, e.End()-1)
+		}
+		for _, o := range l.Entries {
+			if (o != e) && (o.State != StateReleased) && (e.Offset < o.End()) && (o.Offset < e.End()) {
+				t.Fatalf("slice %d to %d overlaps %s slice %d to %d", e.Offset, e.End()-1, o.State, o.Offset, o.End()-1)
+			}
+		}
+		states := []string{StateUsed, StateBurned, StateReleased, StateReserved}
+		if err = l.Set(e, states[prng.Intn(len(states))]); err != nil {
+			t.Fatal(err)
+		}
+		if i%7 == 0 {
+			if l, err = OpenLedger(padName); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestLedgerReuse checks that a released slice is handed out again.
+func TestLedgerReuse(t *testing.T) {
+	padName, dir := testPad(t, 1<<16)
+	defer os.RemoveAll(dir)
+	l, err := OpenLedger(padName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := l.Reserve(PadOverhead, 1<<16, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Set(used, StateUsed); err != nil {
+		t.Fatal(err)
+	}
+	released, err := l.Reserve(PadOverhead, 1<<16, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Set(released, StateReleased); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = OpenLedger(padName); err != nil {
+		t.Fatal(err)
+	}
+	e, err := l.Reserve(PadOverhead, 1<<16, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Offset != released.Offset {
+		t.Fatalf("the slice at %d was reserved instead of the released one at %d", e.Offset, released.Offset)
+	}
+}
+
+// TestLedgerCorrupt checks that damaged ledgers are rejected.
+func TestLedgerCorrupt(t *testing.T) {
+	padName, dir := testPad(t, 1<<16)
+	defer os.RemoveAll(dir)
+	for _, data := range []string{
+		"0 1024 used \"-\"\n1024 10",
+		"0 1024 used \"-\"\n1024 1024 reserved\n",
+		"0 1024 used \"-\"\nfoo 1024 used \"-\"\n",
+		"0 1024 spent \"-\"\n",
+		"-1024 1024 used \"-\"\n",
+		"0 0 used \"-\"\n",
+	} {
+		if err := ioutil.WriteFile(LedgerName(padName), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenLedger(padName); err == nil {
+			t.Fatalf("the ledger %q was accepted", data)
+		}
+	}
+	valid := "# crypt0 pad ledger\n0 1024\n1024 1024 burned \"-\"\n"
+	if err := ioutil.WriteFile(LedgerName(padName), []byte(valid), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenLedger(padName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(l.Entries) != 2) || (l.Entries[0].State != StateUsed) || (l.Next() != 2048) {
+		t.Fatalf("the ledger %q was misread", valid)
+	}
+}
@@ -0,0 +1,347 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A manifest lists the pads of a peer directory made by genpads0, with their
+// size and SHA-512, for the peer receiving the directory. It is authenticated
+// by an HMAC-SHA512 keyed from a pad that genpads0 already shares with this
+// peer (see ManifestKey): the offset key of a pad, a reserved slice that both
+// sides hold and that is never sent. The manifest is a text file:
+//
+//	crypt0 manifest 1
+//	<pad name> <size> <SHA-512 in hexadecimal>
+//	...
+//	key <name of the keying pad, without extension>
+//	mac <HMAC-SHA512 of the previous lines in hexadecimal>
+//
+// Without a shared pad, on a first exchange, there is no key line and the last
+// line is "sha512 <SHA-512 of the previous lines in hexadecimal>": it only
+// detects a damaged manifest, whoever can rewrite a whole directory in
+// transport can rewrite its manifest too. The two peers of a channel then
+// compare the code of their manifests (see ManifestCode) through another
+// channel.
+const ManifestName string = "manifest"
+
+const manifestMagic string = "crypt0 manifest 1"
+
+var ErrManifest = errors.New("invalid manifest")
+var ErrManifestSum = errors.New("the manifest is damaged (wrong SHA-512)")
+var ErrManifestMAC = errors.New("the manifest is not authentic (wrong MAC)")
+var ErrManifestKey = errors.New("the pad keying the manifest is missing")
+
+// ManifestEntry describes a pad.
+type ManifestEntry struct {
+	Name string // the file name of the pad
+	Size int64
+	Sum  []byte // SHA-512
+}
+
+// Manifest is a parsed manifest.
+type Manifest struct {
+	Entries []*ManifestEntry
+	KeyPad  string // the name without extension of the keying pad, empty if not authenticated
+}
+
+// HashPad returns the manifest entry of a pad.
+func HashPad(name string) (*ManifestEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha512.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &ManifestEntry{filepath.Base(name), size, h.Sum(nil)}, nil
+}
+
+// ManifestPads returns the names of the fresh pads (.w.pad and .r.pad files)
+// of a directory.
+func ManifestPads(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() &&
+			(strings.HasSuffix(info.Name(), WritePadExt) || strings.HasSuffix(info.Name(), ReadPadExt)) {
+			ret = append(ret, filepath.Join(dir, info.Name()))
+		}
+	}
+	return ret, nil
+}
+
+// padBaseName returns the name of a pad without its extension.
+func padBaseName(padName string) string {
+	name := filepath.Base(padName)
+	for _, ext := range []string{WritePadExt, ReadPadExt, UsedPadExt} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// ManifestKey derives the key of a manifest from the offset key of a pad,
+// which is the same in the write pad and in its read copy.
+func ManifestKey(padName string) ([]byte, error) {
+	f, err := os.Open(padName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ReadOffsetKey(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha512.New, key)
+	mac.Write([]byte("crypt0 manifest key"))
+	return mac.Sum(nil), nil
+}
+
+// FindKeyPad returns the pad of keysDir, searched recursively, having the name
+// of the keying pad of a manifest, or "" if there is none. The pads of the
+// verified directory, if any, are skipped: they came with the manifest.
+func FindKeyPad(keysDir, verified, keyPad string) (string, error) {
+	pads, err := FindPads(keysDir)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	skipped := ""
+	if verified != "" {
+		if skipped, err = filepath.Abs(verified); err != nil {
+			return "", err
+		}
+	}
+	for _, padName := range pads {
+		if (padBaseName(padName) == keyPad) && (filepath.Dir(padName) != skipped) {
+			return padName, nil
+		}
+	}
+	return "", nil
+}
+
+// ManifestCode returns a short code of the pads of a manifest that the two
+// peers of a channel compare to make sure that they got the same pads. The
+// pads are taken without their extension, a write pad and its read copy give
+// the same line, so that the two directories of a channel have the same code.
+func ManifestCode(entries []*ManifestEntry) string {
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s %d %s\n", padBaseName(e.Name), e.Size, hex.EncodeToString(e.Sum)))
+	}
+	sort.Strings(lines)
+	h := sha512.New()
+	h.Write([]byte("crypt0 manifest code\n"))
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	code := hex.EncodeToString(h.Sum(nil)[:16])
+	var groups []string
+	for i := 0; i < len(code); i += 4 {
+		groups = append(groups, code[i:i+4])
+	}
+	return strings.Join(groups, "-")
+}
+
+// WriteManifest writes the manifest of the entries in dir, authenticated with
+// the key of keyPad (see ManifestKey), or with a SHA-512 only if keyPad is
+// empty.
+func WriteManifest(dir string, entries []*ManifestEntry, keyPad string) error {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s\n", manifestMagic)
+	for _, e := range entries {
+		fmt.Fprintf(&body, "%s %d %s\n", e.Name, e.Size, hex.EncodeToString(e.Sum))
+	}
+	if keyPad == "" {
+		sum := sha512.Sum512(body.Bytes())
+		fmt.Fprintf(&body, "sha512 %s\n", hex.EncodeToString(sum[:]))
+	} else {
+		key, err := ManifestKey(keyPad)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&body, "key %s\n", padBaseName(keyPad))
+		mac := hmac.New(sha512.New, key)
+		mac.Write(body.Bytes())
+		fmt.Fprintf(&body, "mac %s\n", hex.EncodeToString(mac.Sum(nil)))
+	}
+	return WriteFileAtomic(filepath.Join(dir, ManifestName), body.Bytes(), 0600)
+}
+
+// ReadManifest reads the manifest of dir and authenticates it with its keying
+// pad, searched in keysDir (see FindKeyPad).
+func ReadManifest(dir, keysDir string) (*Manifest, error) {
+	f, err := os.Open(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseManifest(f, keysIn(keysDir, dir))
+}
+
+// keysIn returns the key function of ParseManifest for the pads of keysDir,
+// those of the verified directory skipped (see FindKeyPad).
+func keysIn(keysDir, verified string) func(string) ([]byte, error) {
+	return func(keyPad string) ([]byte, error) {
+		padName, err := FindKeyPad(keysDir, verified, keyPad)
+		if err != nil {
+			return nil, err
+		}
+		if padName == "" {
+			return nil, fmt.Errorf("%s: %s", keyPad, ErrManifestKey)
+		}
+		return ManifestKey(padName)
+	}
+}
+
+// ParseManifest reads a manifest and checks its MAC, with the key returned by
+// the key function for the name of the keying pad, or its SHA-512.
+func ParseManifest(src io.Reader, key func(keyPad string) ([]byte, error)) (*Manifest, error) {
+	var body bytes.Buffer
+	r := bufio.NewReader(src)
+	m := &Manifest{}
+	for n := 0; ; n++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, ErrManifest // no last line
+		}
+		fields := strings.Fields(line)
+		switch {
+		case n == 0:
+			if line != manifestMagic+"\n" {
+				return nil, ErrManifest
+			}
+		case (len(fields) == 2) && (fields[0] == "key") && (m.KeyPad == ""):
+			m.KeyPad = fields[1]
+			for _, e := range m.Entries {
+				if padBaseName(e.Name) == m.KeyPad {
+					return nil, ErrManifest // keyed by a pad sent with it
+				}
+			}
+		case (len(fields) == 2) && ((fields[0] == "mac") || (fields[0] == "sha512")):
+			if (fields[0] == "mac") != (m.KeyPad != "") {
+				return nil, ErrManifest
+			}
+			sum, err := hex.DecodeString(fields[1])
+			if err != nil {
+				return nil, ErrManifest
+			}
+			if m.KeyPad == "" {
+				expected := sha512.Sum512(body.Bytes())
+				if !bytes.Equal(sum, expected[:]) {
+					return nil, ErrManifestSum
+				}
+			} else {
+				k, err := key(m.KeyPad)
+				if err != nil {
+					return nil, err
+				}
+				mac := hmac.New(sha512.New, k)
+				mac.Write(body.Bytes())
+				if !hmac.Equal(sum, mac.Sum(nil)) {
+					return nil, ErrManifestMAC
+				}
+			}
+			if _, err = r.ReadByte(); err != io.EOF {
+				return nil, ErrManifest
+			}
+			return m, nil
+		case (len(fields) == 3) && (m.KeyPad == ""):
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, ErrManifest
+			}
+			sum, err := hex.DecodeString(fields[2])
+			if (err != nil) || (len(sum) != sha512.Size) || (filepath.Base(fields[0]) != fields[0]) {
+				return nil, ErrManifest
+			}
+			m.Entries = append(m.Entries, &ManifestEntry{fields[0], size, sum})
+		default:
+			return nil, ErrManifest
+		}
+		body.WriteString(line)
+	}
+}
+
+// VerifyManifest checks that the fresh pads of dir are exactly the pads of its
+// manifest, with the same size and SHA-512, once the manifest is authenticated
+// with its keying pad searched in keysDir. The problems are reported through
+// the log function. It returns the manifest and an error if a pad does not
+// match.
+func VerifyManifest(dir, keysDir string, log func(string)) (*Manifest, error) {
+	m, err := ReadManifest(dir, keysDir)
+	if err != nil {
+		return nil, err
+	}
+	failed := 0
+	listed := make(map[string]bool)
+	for _, e := range m.Entries {
+		listed[e.Name] = true
+		found, err := HashPad(filepath.Join(dir, e.Name))
+		switch {
+		case err != nil:
+			log(err.Error())
+		case found.Size != e.Size:
+			log(fmt.Sprintf("`%s`: %d bytes, %d expected", e.Name, found.Size, e.Size))
+		case !bytes.Equal(found.Sum, e.Sum):
+			log(fmt.Sprintf("`%s`: altered (wrong SHA-512)", e.Name))
+		default:
+			continue
+		}
+		failed++
+	}
+	pads, err := ManifestPads(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, padName := range pads {
+		if !listed[filepath.Base(padName)] {
+			log(fmt.Sprintf("`%s`: not in the manifest", filepath.Base(padName)))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("%s: %d pads do not match the manifest", dir, failed)
+	}
+	return m, nil
+}
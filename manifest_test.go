@@ -0,0 +1,176 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestManifestCode checks that the two directories of a channel have the same
+// code, and that a rewritten pad changes it even with a rewritten manifest.
+func TestManifestCode(t *testing.T) {
+	root, err := ioutil.TempDir("", "crypt0-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	alice, bob := filepath.Join(root, "alice"), filepath.Join(root, "bob")
+	pads := map[string]string{
+		"5f0e2b8c6d1a4e97b3c2a18d9e7f6051": filepath.Join(alice, "5f0e2b8c6d1a4e97b3c2a18d9e7f6051.w.pad"),
+		"c47a9d03e2b1f86a5d9c0e7b3a214f68": filepath.Join(bob, "c47a9d03e2b1f86a5d9c0e7b3a214f68.w.pad"),
+	}
+	for _, dir := range []string{alice, bob} {
+		if err = os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for base, padName := range pads {
+		pad := random(t, 4096)
+		copyName := filepath.Join(alice, base+ReadPadExt)
+		if filepath.Dir(padName) == alice {
+			copyName = filepath.Join(bob, base+ReadPadExt)
+		}
+		for _, name := range []string{padName, copyName} {
+			if err = ioutil.WriteFile(name, pad, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write := func(dir, keyPad string) {
+		names, err := ManifestPads(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []*ManifestEntry
+		for _, name := range names {
+			e, err := HashPad(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, e)
+		}
+		if err = WriteManifest(dir, entries, keyPad); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verify := func(dir string) string {
+		m, err := VerifyManifest(dir, root, func(msg string) { t.Log(msg) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ManifestCode(m.Entries)
+	}
+	write(alice, "")
+	write(bob, "")
+	code := verify(alice)
+	if verify(bob) != code {
+		t.Fatal("the two directories of a channel have different codes")
+	}
+	if err = ioutil.WriteFile(pads["c47a9d03e2b1f86a5d9c0e7b3a214f68"], random(t, 4096), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = VerifyManifest(bob, root, func(string) {}); err == nil {
+		t.Fatal("a rewritten pad matches the manifest")
+	}
+	write(bob, "")
+	if verify(bob) == code {
+		t.Fatal("a rewritten pad and manifest keep the code")
+	}
+	name := filepath.Join(bob, ManifestName)
+	manifest, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest[len(manifestMagic)+1] ^= 1 // first pad name
+	if err = ioutil.WriteFile(name, manifest, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadManifest(bob, root); err != ErrManifestSum {
+		t.Fatalf("a damaged manifest: %v", err)
+	}
+}
+
+// TestManifestMAC checks that a manifest keyed with a pad shared beforehand is
+// authenticated with the copy of the pad held by the peer, and that it cannot
+// be rewritten without this pad.
+func TestManifestMAC(t *testing.T) {
+	root, err := ioutil.TempDir("", "crypt0-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	sender, peer, received := filepath.Join(root, "sender"), filepath.Join(root, "peer"), filepath.Join(root, "received")
+	for _, dir := range []string{sender, peer, received} {
+		if err = os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared := random(t, 4096)
+	keyPad := filepath.Join(sender, "9b1e5c7d2a4f8e603d6c1b9a7e5f2d48"+WritePadExt)
+	for _, name := range []string{keyPad, filepath.Join(peer, "9b1e5c7d2a4f8e603d6c1b9a7e5f2d48"+ReadPadExt)} {
+		if err = ioutil.WriteFile(name, shared, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	padName := filepath.Join(received, "5f0e2b8c6d1a4e97b3c2a18d9e7f6051"+ReadPadExt)
+	if err = ioutil.WriteFile(padName, random(t, 4096), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := HashPad(padName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteManifest(received, []*ManifestEntry{e}, keyPad); err != nil {
+		t.Fatal(err)
+	}
+	m, err := VerifyManifest(received, peer, func(msg string) { t.Log(msg) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.KeyPad != "9b1e5c7d2a4f8e603d6c1b9a7e5f2d48" {
+		t.Fatalf("keying pad %q", m.KeyPad)
+	}
+	if _, err = ReadManifest(received, received); err == nil {
+		t.Fatal("a manifest authenticated without its keying pad")
+	}
+	// Rewritten with another key: the pad of the manifest itself, sent with it
+	if err = WriteManifest(received, []*ManifestEntry{e}, padName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadManifest(received, root); err != ErrManifest {
+		t.Fatalf("a manifest keyed by its own pad: %v", err)
+	}
+	// Altered after its MAC
+	if err = WriteManifest(received, []*ManifestEntry{e}, keyPad); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(received, ManifestName)
+	manifest, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest[len(manifestMagic)+1] ^= 1 // first pad name
+	if err = ioutil.WriteFile(name, manifest, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadManifest(received, peer); err != ErrManifestMAC {
+		t.Fatalf("a forged manifest: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A pad bundle carries the pads of a peer directory made by genpads0 on
+// removable media. It is a tar archive of a single directory named after the
+// peer, holding the manifest, then the pads of the manifest in its order, so
+// that a bundle is checked in a single pass.
+const PadBundleExt string = ".pads.tar"
+
+var ErrPadBundle = errors.New("invalid pad bundle")
+var ErrPadCode = errors.New("the code of the manifest is not the code of the peer")
+
+// WritePadBundle writes the pads of dir, listed by its manifest authenticated
+// with a pad of keysDir, as a bundle for the given peer.
+func WritePadBundle(dst io.Writer, dir, peer, keysDir string) error {
+	m, err := ReadManifest(dir, keysDir)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(dst)
+	names := []string{ManifestName}
+	for _, e := range m.Entries {
+		names = append(names, e.Name)
+	}
+	now := time.Now().Truncate(time.Second)
+	if err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: peer + "/", Mode: 0700, ModTime: now}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: peer + "/" + name, Mode: 0600, Size: info.Size(), ModTime: now}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err = copyFile(tw, filepath.Join(dir, name), hdr.Size); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// CheckPadBundle reads a whole bundle and checks that it holds exactly the pads
+// of its manifest, authenticated with a pad of keysDir. It returns the peer and
+// the manifest of the bundle.
+func CheckPadBundle(src io.Reader, keysDir string) (string, *Manifest, error) {
+	tr := tar.NewReader(src)
+	hdr, err := tr.Next()
+	if err != nil {
+		return "", nil, err
+	}
+	peer := strings.TrimSuffix(hdr.Name, "/")
+	if (hdr.Typeflag != tar.TypeDir) || !validPeer(peer) {
+		return "", nil, ErrPadBundle
+	}
+	next := func(name string) error {
+		hdr, err := tr.Next()
+		if err != nil {
+			return err
+		}
+		if (hdr.Typeflag != tar.TypeReg) || (hdr.Name != peer+"/"+name) {
+			return fmt.Errorf("%s: %s", hdr.Name, ErrPadBundle)
+		}
+		return nil
+	}
+	if err = next(ManifestName); err != nil {
+		return "", nil, err
+	}
+	m, err := ParseManifest(tr, keysIn(keysDir, ""))
+	if err != nil {
+		return "", nil, err
+	}
+	for _, e := range m.Entries {
+		if err = next(e.Name); err != nil {
+			return "", nil, err
+		}
+		h := sha512.New()
+		size, err := io.Copy(h, tr)
+		if err != nil {
+			return "", nil, err
+		}
+		if (size != e.Size) || !bytes.Equal(h.Sum(nil), e.Sum) {
+			return "", nil, fmt.Errorf("%s: does not match the manifest", e.Name)
+		}
+	}
+	if _, err = tr.Next(); err != io.EOF {
+		return "", nil, fmt.Errorf("%s: entries after the pads", ErrPadBundle)
+	}
+	return peer, m, nil
+}
+
+// validPeer tells if a peer name can name a directory of PeersDir.
+func validPeer(peer string) bool {
+	return (peer != "") && (peer != ".") && (peer != "..") && !strings.ContainsAny(peer, `/\`)
+}
+
+// ImportPadBundle installs the pads of a bundle into the directory of a peer
+// in peersDir, the peer of the bundle if peer is empty. The bundle is
+// extracted in a temporary directory of peersDir and checked against its
+// manifest, authenticated with a pad already in peersDir if it has a MAC, then
+// the pads are renamed into place: all of them, or none if a pad of the same
+// name is already somewhere in peersDir. With a code, given by the peer through
+// another channel, nothing is installed unless it is the code of the manifest.
+// It returns the directory of the peer, the manifest and the new pads.
+func ImportPadBundle(src io.Reader, peersDir, peer, code string) (string, *Manifest, []string, error) {
+	if err := os.MkdirAll(peersDir, 0700); err != nil {
+		return "", nil, nil, err
+	}
+	tmp, err := ioutil.TempDir(peersDir, ".import-")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err = ExtractArchive(src, tmp, func(string) {}); err != nil {
+		return "", nil, nil, err
+	}
+	found, err := ListPeers(tmp)
+	if (err == nil) && (len(found) != 1) {
+		err = ErrPadBundle
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if peer == "" {
+		peer = found[0]
+	}
+	if !validPeer(peer) {
+		return "", nil, nil, fmt.Errorf("%s: invalid peer name", peer)
+	}
+	dir := filepath.Join(tmp, found[0])
+	var problems []string
+	m, err := VerifyManifest(dir, peersDir, func(msg string) { problems = append(problems, msg) })
+	if err != nil {
+		if len(problems) > 0 {
+			err = errors.New(strings.Join(problems, ", "))
+		}
+		return "", nil, nil, err
+	}
+	if verified := ManifestCode(m.Entries); (code != "") && (strings.ToLower(strings.TrimSpace(code)) != verified) {
+		return "", nil, nil, fmt.Errorf("%s (%s)", ErrPadCode, verified)
+	}
+	pads, err := ManifestPads(dir)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	existing, err := FindPads(peersDir)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	known := make(map[string]string)
+	root, err := filepath.Abs(tmp)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	for _, padName := range existing {
+		if !strings.HasPrefix(padName, root+string(filepath.Separator)) {
+			known[padBaseName(padName)] = padName
+		}
+	}
+	for _, padName := range pads {
+		if known[padBaseName(padName)] != "" {
+			return "", nil, nil, fmt.Errorf("%s: the pad is already imported (%s)", filepath.Base(padName), known[padBaseName(padName)])
+		}
+	}
+	dst := filepath.Join(peersDir, peer)
+	if err = os.MkdirAll(dst, 0700); err != nil {
+		return "", nil, nil, err
+	}
+	var ret []string
+	for _, padName := range pads {
+		target := filepath.Join(dst, filepath.Base(padName))
+		if err = os.Rename(padName, target); err != nil {
+			// Put back the pads already moved
+			for _, moved := range ret {
+				os.Rename(moved, filepath.Join(dir, filepath.Base(moved)))
+			}
+			return "", nil, nil, err
+		}
+		ret = append(ret, target)
+	}
+	return dst, m, ret, SyncDir(dst)
+}
@@ -0,0 +1,124 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PadNameSize is the size in bytes of the random names of the pads.
+const PadNameSize int = 16
+
+// NewPadName returns a random pad name: PadNameSize bytes in hexadecimal.
+// Older pads are named after the hexadecimal Unix time of their generation in
+// nanoseconds; both forms are valid, the name of a pad is only compared to the
+// names of the other pads.
+func NewPadName() (string, error) {
+	name := make([]byte, PadNameSize)
+	if _, err := rand.Read(name); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(name), nil
+}
+
+// ImportPads copies the fresh pads (.w.pad and .r.pad files) of src, a peer
+// directory made by genpads0, into dst, created if needed. Nothing is copied if
+// a pad was already used or if dst already has a pad of the same name, whatever
+// its state. The copies are synced and checked against the size of the
+// originals. It returns the names of the new pads.
+func ImportPads(src, dst string) ([]string, error) {
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return nil, err
+	}
+	var todo []os.FileInfo
+	for _, info := range infos {
+		name := info.Name()
+		ext := WritePadExt
+		if strings.HasSuffix(name, ReadPadExt) {
+			ext = ReadPadExt
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if _, err = os.Stat(LedgerName(filepath.Join(src, name))); err == nil {
+			return nil, fmt.Errorf("%s: the pad was already used", filepath.Join(src, name))
+		}
+		for _, e := range []string{WritePadExt, ReadPadExt, UsedPadExt} {
+			existing := filepath.Join(dst, strings.TrimSuffix(name, ext)+e)
+			if _, err = os.Lstat(existing); err == nil {
+				return nil, fmt.Errorf("%s: the pad is already imported", existing)
+			}
+		}
+		todo = append(todo, info)
+	}
+	if err = os.MkdirAll(dst, 0700); err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, info := range todo {
+		target := filepath.Join(dst, info.Name())
+		if err = copyPad(filepath.Join(src, info.Name()), target, info.Size()); err != nil {
+			return ret, err
+		}
+		ret = append(ret, target)
+	}
+	return ret, SyncDir(dst)
+}
+
+func copyPad(src, dst string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, in)
+	if (err == nil) && (n != size) {
+		err = fmt.Errorf("%s: %d bytes copied, %d expected", src, n, size)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// BurnPad destroys a pad (see DestroyPad) unless it is in use.
+func BurnPad(name string) error {
+	if err := LockPad(name); err != nil {
+		return err
+	}
+	err := DestroyPad(name)
+	UnlockPad(name)
+	return err
+}
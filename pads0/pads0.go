@@ -0,0 +1,543 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+// Package pads0 is the `crypt0 pads` command, also run as pads0.
+package pads0
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/piotrcki/crypt0"
+	"github.com/piotrcki/crypt0/cli"
+)
+
+var Args []string // the command line, Args[0] is the command name
+var Directory string = ""
+var Peers []string
+var Direction string = ""
+var State string = ""
+var JSON bool = false
+var Thresholds crypt0.Thresholds
+var Wipe bool = false
+var BundleName string = ""
+var PeerName string = ""
+var Code string = "" // the code of the peer, checked by import
+var PadNames []string
+var PieceSize int64 = 0
+var Locked []string // the pads locked by split or merge
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n\n")
+	fmt.Fprintf(os.Stderr, "%s recover [directory]\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s rebuild [directory]\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s query [--peer peer]... [--direction w|r] [--state state] [--json]\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s inventory [--peer peer]... [--direction w|r] [--min-pads n] [--min-bytes n] [--min-message n] [--json] [directory]\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s verify directory\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s export [--wipe] directory bundle\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s import [--code code] bundle [peer]\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s split pad size\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "%s merge pad pad...\n\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "recover      : reconcile the pad ledgers with the files after a crash, no encrypt0 must be running\n")
+	fmt.Fprintf(os.Stderr, "rebuild      : update the pad index of $CRYPT0_HOME from the pads found in the directory and forget the pads that no longer exist\n")
+	fmt.Fprintf(os.Stderr, "query        : list the pads of the pad index\n")
+	fmt.Fprintf(os.Stderr, "inventory    : update the pad index like rebuild and sum up the pads of the directory per peer and direction\n")
+	fmt.Fprintf(os.Stderr, "verify       : check the pads of a directory made by genpads0, or of its subdirectories, against their manifest before their first use\n")
+	fmt.Fprintf(os.Stderr, "export       : check the pads of a directory made by genpads0 against their manifest and write them to a bundle file\n")
+	fmt.Fprintf(os.Stderr, "import       : install the pads of a bundle in $CRYPT0_HOME/peers/peer, the peer of the bundle by default\n")
+	fmt.Fprintf(os.Stderr, "split        : cut an unused pad in pieces of size bytes, the peer must split its copy of the pad the same way\n")
+	fmt.Fprintf(os.Stderr, "merge        : concatenate unused pads of a directory in one pad, the peer must merge its copies of the pads the same way\n")
+	fmt.Fprintf(os.Stderr, "--wipe       : destroy the pads of the directory once the bundle is written and checked\n")
+	fmt.Fprintf(os.Stderr, "--code       : the code printed by the peer, the pads are not imported if the bundle has another code\n")
+	fmt.Fprintf(os.Stderr, "directory    : the directory where pads are searched recursively ($CRYPT0_HOME/peers by default)\n")
+	fmt.Fprintf(os.Stderr, "--peer       : only list the pads of this peer, can be repeated\n")
+	fmt.Fprintf(os.Stderr, "--direction  : only list the write (w) or the read (r) pads\n")
+	fmt.Fprintf(os.Stderr, "--state      : only list the pads in this state (fresh, in-use, exhausted, pending or unknown)\n")
+	fmt.Fprintf(os.Stderr, "--min-pads   : a channel having fewer unused pads is running low\n")
+	fmt.Fprintf(os.Stderr, "--min-bytes  : a channel having fewer remaining bytes is running low\n")
+	fmt.Fprintf(os.Stderr, "--min-message: a channel that cannot encrypt a message of this size in bytes is running low\n")
+	fmt.Fprintf(os.Stderr, "--json       : print in JSON\n\n")
+	fmt.Fprintf(os.Stderr, "Return values:\n\n")
+	fmt.Fprintf(os.Stderr, "0: success\n")
+	fmt.Fprintf(os.Stderr, "1: a channel is running low (inventory) or the pads do not match their manifest (verify)\n")
+	fmt.Fprintf(os.Stderr, "9: error\n")
+	cli.Exit(cli.ExitError)
+}
+
+// Cleanup releases the resources, it is called by cli.Exit.
+func Cleanup(status int) {
+	for _, padName := range Locked {
+		crypt0.UnlockPad(padName)
+	}
+	Locked = nil
+}
+
+func ParseArgs() {
+	if len(Args) < 2 {
+		Usage()
+	}
+	Directory = crypt0.PeersDir()
+	Thresholds = cli.Conf.Thresholds
+	if (Args[1] == "import") && (len(Args) > 4) && (Args[2] == "--code") {
+		Code = Args[3]
+		Args = append([]string{Args[0], Args[1]}, Args[4:]...)
+	}
+	switch {
+	case (Args[1] == "export") && (len(Args) == 5) && (Args[2] == "--wipe"):
+		Wipe, Directory, BundleName = true, Args[3], Args[4]
+		return
+	case (Args[1] == "export") && (len(Args) == 4):
+		Directory, BundleName = Args[2], Args[3]
+		return
+	case (Args[1] == "import") && ((len(Args) == 3) || (len(Args) == 4)):
+		BundleName = Args[2]
+		if len(Args) == 4 {
+			PeerName = Args[3]
+		}
+		return
+	case (Args[1] == "split") && (len(Args) == 4):
+		n, err := strconv.ParseInt(Args[3], 10, 64)
+		if err != nil {
+			Usage()
+		}
+		PadNames, PieceSize = Args[2:3], n
+		return
+	case (Args[1] == "merge") && (len(Args) > 3):
+		PadNames = Args[2:]
+		return
+	case (Args[1] == "export") || (Args[1] == "import") || (Args[1] == "split") || (Args[1] == "merge"):
+		Usage()
+	}
+	if (Args[1] != "query") && (Args[1] != "inventory") {
+		if (len(Args) > 3) || ((Args[1] == "verify") && (len(Args) != 3)) {
+			Usage()
+		}
+		if len(Args) == 3 {
+			Directory = Args[2]
+		}
+		return
+	}
+	number := func(i int) int64 {
+		n, err := strconv.ParseInt(Args[i], 10, 64)
+		if (err != nil) || (n < 0) {
+			Usage()
+		}
+		return n
+	}
+	for i := 2; i < len(Args); i++ {
+		switch {
+		case Args[i] == "--json":
+			JSON = true
+		case (Args[i] == "--peer") && (i+1 < len(Args)):
+			i++
+			Peers = append(Peers, Args[i])
+		case (Args[i] == "--direction") && (i+1 < len(Args)):
+			i++
+			Direction = Args[i]
+		case (Args[i] == "--state") && (i+1 < len(Args)) && (Args[1] == "query"):
+			i++
+			State = Args[i]
+		case (Args[i] == "--min-pads") && (i+1 < len(Args)) && (Args[1] == "inventory"):
+			i++
+			Thresholds.Pads = int(number(i))
+		case (Args[i] == "--min-bytes") && (i+1 < len(Args)) && (Args[1] == "inventory"):
+			i++
+			Thresholds.Bytes = number(i)
+		case (Args[i] == "--min-message") && (i+1 < len(Args)) && (Args[1] == "inventory"):
+			i++
+			Thresholds.Message = number(i)
+		case (i == len(Args)-1) && !strings.HasPrefix(Args[i], "--") && (Args[1] == "inventory"):
+			Directory = Args[i]
+		default:
+			Usage()
+		}
+	}
+}
+
+// Selected tells if the records of a peer are selected by the --peer options.
+func Selected(peer string) bool {
+	if len(Peers) == 0 {
+		return true
+	}
+	for _, p := range Peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// FindJournaled returns the pads having a ledger or a lock.
+func FindJournaled() []string {
+	found := make(map[string]bool)
+	err := filepath.Walk(Directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, crypt0.LedgerExt) {
+			found[strings.TrimSuffix(path, crypt0.LedgerExt)] = true
+		} else if strings.HasSuffix(path, crypt0.LockExt) {
+			found[strings.TrimSuffix(path, crypt0.LockExt)] = true
+		}
+		return nil
+	})
+	cli.FatalCheck(err)
+	var ret []string
+	for padName := range found {
+		ret = append(ret, padName)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func Recover() {
+	failed := false
+	for _, padName := range FindJournaled() {
+		if err := crypt0.Recover(padName, cli.Report); err != nil {
+			cli.Error(fmt.Sprintf("%s: %s", padName, err))
+			failed = true
+		}
+	}
+	if failed {
+		cli.Exit(cli.ExitError)
+	}
+}
+
+// Refresh updates the pad index from the pads of the directory and saves it.
+func Refresh(report func(string)) *crypt0.Index {
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	cli.FatalCheck(err)
+	cli.FatalCheck(index.Refresh(Directory, report))
+	cli.FatalCheck(index.Save())
+	return index
+}
+
+func Rebuild() {
+	Refresh(cli.Report)
+}
+
+func Query() {
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	cli.FatalCheck(err)
+	records := []*crypt0.PadRecord{}
+	for _, r := range index.Pads {
+		if Selected(r.Peer) && ((Direction == "") || (r.Direction == Direction)) &&
+			((State == "") || (r.State == State)) {
+			records = append(records, r)
+		}
+	}
+	if JSON {
+		data, err := json.MarshalIndent(records, "", "\t")
+		cli.FatalCheck(err)
+		fmt.Printf("%s\n", data)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tPEER\tDIRECTION\tSIZE\tREMAINING\tSTATE\tCREATED\tPATH\n")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", r.ID, r.Peer, r.Direction, r.Size, r.Remaining,
+			r.State, r.Created.Format("2006-01-02 15:04:05"), r.Path)
+	}
+	w.Flush()
+}
+
+// Inventory sums up the pads of the directory per peer and direction and exits
+// with cli.ExitPad if a channel is below the thresholds. Every subdirectory of
+// the directory is a peer, listed even when it has no pad left.
+func Inventory() {
+	index := Refresh(func(string) {})
+	within, err := index.Within(Directory)
+	cli.FatalCheck(err)
+	var records []*crypt0.PadRecord
+	for _, r := range within {
+		if Selected(r.Peer) {
+			records = append(records, r)
+		}
+	}
+	peers := Peers
+	if len(peers) == 0 {
+		peers, err = crypt0.ListPeers(Directory)
+		cli.FatalCheck(err)
+	}
+	stocks := []*crypt0.Stock{}
+	low := false
+	for _, s := range crypt0.Inventory(records, peers) {
+		if (Direction != "") && (s.Direction != Direction) {
+			continue
+		}
+		if s.Check(Thresholds) {
+			low = true
+			if !JSON {
+				cli.Warning(fmt.Sprintf("`%s` (%s) is running low: %s", s.Peer, s.Direction, strings.Join(s.Low, ", ")))
+			}
+		}
+		stocks = append(stocks, s)
+	}
+	if JSON {
+		data, err := json.MarshalIndent(stocks, "", "\t")
+		cli.FatalCheck(err)
+		fmt.Printf("%s\n", data)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "PEER\tDIRECTION\tUNUSED\tIN-USE\tEXHAUSTED\tPENDING\tBYTES\tLARGEST\tUSED\tBURNED\tSTATUS\n")
+		for _, s := range stocks {
+			status := "ok"
+			if len(s.Low) > 0 {
+				status = "low"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", s.Peer, s.Direction, s.Unused, s.InUse,
+				s.Exhausted, s.Pending, s.Bytes, s.Largest, s.Used, s.Burned, status)
+		}
+		w.Flush()
+	}
+	if low {
+		cli.Exit(cli.ExitPad)
+	}
+}
+
+// Verify checks the pads of the directory, or of its subdirectories, against
+// their manifests and exits with cli.ExitPad if they do not match.
+func Verify() {
+	dirs := []string{Directory}
+	if _, err := os.Stat(filepath.Join(Directory, crypt0.ManifestName)); os.IsNotExist(err) {
+		peers, err := crypt0.ListPeers(Directory)
+		cli.FatalCheck(err)
+		dirs = nil
+		for _, peer := range peers {
+			dir := filepath.Join(Directory, peer)
+			if _, err = os.Stat(filepath.Join(dir, crypt0.ManifestName)); err == nil {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) == 0 {
+			cli.FatalError(fmt.Sprintf("no manifest in `%s`.", Directory))
+		}
+	}
+	failed := false
+	for _, dir := range dirs {
+		m, err := crypt0.VerifyManifest(dir, crypt0.PeersDir(), func(msg string) {
+			cli.Error(fmt.Sprintf("%s: %s", dir, msg))
+		})
+		if err != nil {
+			cli.Error(fmt.Sprintf("%s: %s", dir, err))
+			failed = true
+			continue
+		}
+		code := crypt0.ManifestCode(m.Entries)
+		if m.KeyPad != "" {
+			cli.Report(fmt.Sprintf("`%s` matches its manifest authenticated with the pad %s, code %s.", dir, m.KeyPad, code))
+		} else {
+			cli.Report(fmt.Sprintf("`%s` matches its manifest, code %s: the manifest is not authenticated, check that your peer has the same code.", dir, code))
+		}
+	}
+	if failed {
+		cli.Exit(cli.ExitPad)
+	}
+	cli.Exit(cli.ExitSuccess)
+}
+
+// Export writes the pads of the directory to a new bundle, reads the bundle
+// back to check it and, with --wipe, destroys the pads of the directory.
+func Export() {
+	m, err := crypt0.ReadManifest(Directory, crypt0.PeersDir())
+	if os.IsNotExist(err) {
+		cli.FatalError(fmt.Sprintf("no manifest in `%s`, only the directories made by genpads0 can be exported.", Directory))
+	}
+	cli.FatalCheck(err)
+	entries := m.Entries
+	for _, e := range entries {
+		padName := filepath.Join(Directory, e.Name)
+		if _, err = os.Stat(crypt0.LedgerName(padName)); err == nil {
+			cli.FatalError(fmt.Sprintf("`%s` was already used, it cannot be exported.", padName))
+		}
+	}
+	_, err = crypt0.VerifyManifest(Directory, crypt0.PeersDir(), func(msg string) {
+		cli.Error(fmt.Sprintf("%s: %s", Directory, msg))
+	})
+	cli.FatalCheck(err)
+	code := crypt0.ManifestCode(entries)
+	abs, err := filepath.Abs(Directory)
+	cli.FatalCheck(err)
+	f, err := os.OpenFile(BundleName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	cli.FatalCheck(err)
+	err = crypt0.WritePadBundle(f, Directory, filepath.Base(abs), crypt0.PeersDir())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		f, err = os.Open(BundleName)
+	}
+	if err == nil {
+		var checked *crypt0.Manifest
+		_, checked, err = crypt0.CheckPadBundle(f, crypt0.PeersDir())
+		f.Close()
+		if (err == nil) && (crypt0.ManifestCode(checked.Entries) != code) {
+			err = crypt0.ErrPadBundle
+		}
+	}
+	if err != nil {
+		os.Remove(BundleName)
+		cli.FatalError(fmt.Sprintf("%s: %s", BundleName, err))
+	}
+	cli.Report(fmt.Sprintf("%d pads of `%s` exported to `%s` and checked, code %s.", len(entries), Directory, BundleName, code))
+	if !Wipe {
+		return
+	}
+	for _, e := range entries {
+		cli.FatalCheck(crypt0.BurnPad(filepath.Join(Directory, e.Name)))
+	}
+	cli.FatalCheck(os.Remove(filepath.Join(Directory, crypt0.ManifestName)))
+	if err = os.Remove(Directory); err != nil {
+		cli.Warning(fmt.Sprintf("`%s` kept: %s", Directory, err))
+	}
+	cli.Report(fmt.Sprintf("%d pads of `%s` destroyed.", len(entries), Directory))
+}
+
+// Import installs the pads of a bundle in the directory of their peer.
+func Import() {
+	f, err := os.Open(BundleName)
+	cli.FatalCheck(err)
+	defer f.Close()
+	dir, m, pads, err := crypt0.ImportPadBundle(f, Directory, PeerName, Code)
+	if err != nil {
+		cli.FatalError(fmt.Sprintf("%s: %s", BundleName, err))
+	}
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	cli.FatalCheck(err)
+	cli.FatalCheck(index.Fresh(pads...))
+	cli.FatalCheck(index.Save())
+	Refresh(func(string) {})
+	code := crypt0.ManifestCode(m.Entries)
+	if m.KeyPad != "" {
+		cli.Report(fmt.Sprintf("%d pads imported in `%s`, manifest authenticated with the pad %s, code %s.", len(pads), dir, m.KeyPad, code))
+		return
+	}
+	if Code != "" {
+		cli.Report(fmt.Sprintf("%d pads imported in `%s`, code %s, the code of your peer.", len(pads), dir, code))
+		return
+	}
+	cli.Report(fmt.Sprintf("%d pads imported in `%s`, code %s: check that your peer has the same code.", len(pads), dir, code))
+}
+
+// Rearrange splits or merges the pads given on the command line: they must be
+// unused pads of the same direction, in the same directory. The plan is
+// reported with its code, the peer must get the same code.
+func Rearrange() {
+	var dir, ext string
+	var names []string
+	var sizes []int64
+	var pads []string
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	cli.FatalCheck(err)
+	for _, arg := range PadNames {
+		padName, err := filepath.Abs(arg)
+		cli.FatalCheck(err)
+		padExt := crypt0.WritePadExt
+		if strings.HasSuffix(padName, crypt0.ReadPadExt) {
+			padExt = crypt0.ReadPadExt
+		} else if !strings.HasSuffix(padName, crypt0.WritePadExt) {
+			cli.FatalError(fmt.Sprintf("`%s` is not a .w.pad or a .r.pad.", arg))
+		}
+		if dir == "" {
+			dir, ext = filepath.Dir(padName), padExt
+		} else if (filepath.Dir(padName) != dir) || (padExt != ext) {
+			cli.FatalError("the pads to merge must be in the same directory and have the same direction.")
+		}
+		if _, err = os.Stat(crypt0.LedgerName(padName)); err == nil {
+			cli.FatalError(fmt.Sprintf("`%s` was already used, it cannot be split or merged.", arg))
+		}
+		r, err := index.Update(padName)
+		cli.FatalCheck(err)
+		if r == nil {
+			cli.FatalError(fmt.Sprintf("`%s` does not exist.", arg))
+		}
+		if r.State == crypt0.PadUnknown {
+			cli.FatalError(fmt.Sprintf("`%s` was indexed from its file, its used slices are unknown: it cannot be split or merged.", arg))
+		}
+		if r.State != crypt0.PadFresh {
+			cli.FatalError(fmt.Sprintf("`%s` is %s, only unused pads can be split or merged.", arg, r.State))
+		}
+		pads = append(pads, padName)
+		names = append(names, r.ID)
+		sizes = append(sizes, r.Size)
+	}
+	if _, err = os.Stat(filepath.Join(dir, crypt0.ManifestName)); err == nil {
+		cli.FatalError(fmt.Sprintf("`%s` has a manifest, export or import its pads before splitting or merging them.", dir))
+	}
+	var plan *crypt0.Plan
+	if Args[1] == "split" {
+		plan, err = crypt0.SplitPlan(names[0], sizes[0], PieceSize)
+	} else {
+		plan, err = crypt0.MergePlan(names, sizes)
+	}
+	cli.FatalCheck(err)
+	for _, padName := range pads {
+		if err = crypt0.LockPad(padName); err != nil {
+			cli.FatalError(fmt.Sprintf("%s: %s", padName, err))
+		}
+		Locked = append(Locked, padName)
+	}
+	cli.FatalCheck(plan.Apply(dir, ext))
+	for _, padName := range pads {
+		index.Remove(padName)
+	}
+	for _, t := range plan.To {
+		cli.FatalCheck(index.Fresh(filepath.Join(dir, t.Name+ext)))
+		cli.Report(fmt.Sprintf("`%s` created, %d bytes.", filepath.Join(dir, t.Name+ext), t.Size))
+	}
+	cli.FatalCheck(index.Save())
+	cli.Report(fmt.Sprintf("plan recorded in `%s`, code %s: check that your peer has the same code.", plan.PlanFile(dir), plan.Code()))
+}
+
+// Main runs the command with its arguments.
+func Main(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	cli.AtExit(Cleanup)
+	ParseArgs()
+	switch Args[1] {
+	case "recover":
+		Recover()
+	case "rebuild":
+		Rebuild()
+	case "query":
+		Query()
+		cli.Exit(cli.ExitSuccess)
+	case "inventory":
+		Inventory()
+		cli.Exit(cli.ExitSuccess)
+	case "verify":
+		Verify()
+	case "export":
+		Export()
+	case "import":
+		Import()
+	case "split", "merge":
+		Rearrange()
+	default:
+		Usage()
+	}
+	fmt.Printf("%s: success.\n", cli.Name)
+	cli.Exit(cli.ExitSuccess)
+}
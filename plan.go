@@ -0,0 +1,221 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A pad plan records how pads were split or merged. Both peers of a channel
+// apply the same plan to their copy, the .w.pad on one side and the .r.pad on
+// the other: the plan and the names of the new pads only depend on the names
+// and sizes of the original pads, so both sides get the same pads and the same
+// plan, stored next to the new pads:
+//
+//	crypt0 pad plan 1
+//	split|merge
+//	from <pad name> <offset> <size>
+//	...
+//	to <pad name> <offset> <size>
+//	...
+//
+// A split cuts a pad in pieces of the same size, the last one taking the rest;
+// the offsets of the new pads are in the original pad. A merge concatenates
+// pads in the order of their names; the offsets of the original pads are in
+// the new pad.
+const PlanExt string = ".plan"
+const PlanSplit string = "split"
+const PlanMerge string = "merge"
+
+const planMagic string = "crypt0 pad plan 1"
+
+var ErrPlan = errors.New("the pads cannot be split or merged")
+
+// Plan is a pad plan.
+type Plan struct {
+	Op   string
+	From []*PlanPad
+	To   []*PlanPad
+}
+
+// PlanPad is a pad of a plan, named without extension.
+type PlanPad struct {
+	Name   string
+	Offset int64
+	Size   int64
+}
+
+// planName derives the name of a new pad.
+func planName(parts ...string) string {
+	h := sha512.New()
+	for _, part := range parts {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(part)))
+		h.Write(length[:])
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:PadNameSize])
+}
+
+// SplitPlan returns the plan cutting a pad in pieces of the given size. A last
+// piece too short for a message and an offset key is left to the previous one.
+func SplitPlan(name string, size, piece int64) (*Plan, error) {
+	min := PadOverhead + OffsetKeySize
+	if (piece <= min) || (size-piece <= min) {
+		return nil, fmt.Errorf("%s: pieces of %d bytes out of %d: %s", name, piece, size, ErrPlan)
+	}
+	p := &Plan{Op: PlanSplit, From: []*PlanPad{{name, 0, size}}}
+	for offset := int64(0); offset < size; offset += piece {
+		length := piece
+		if size-offset-piece <= min {
+			length = size - offset
+		}
+		p.To = append(p.To, &PlanPad{planName(PlanSplit, name, fmt.Sprint(offset), fmt.Sprint(length)), offset, length})
+		if length != piece {
+			break
+		}
+	}
+	return p, nil
+}
+
+// MergePlan returns the plan concatenating pads, given by name and size.
+func MergePlan(names []string, sizes []int64) (*Plan, error) {
+	if len(names) < 2 {
+		return nil, ErrPlan
+	}
+	p := &Plan{Op: PlanMerge}
+	for i, name := range names {
+		p.From = append(p.From, &PlanPad{name, 0, sizes[i]})
+	}
+	sort.Slice(p.From, func(i, j int) bool { return p.From[i].Name < p.From[j].Name })
+	parts := []string{PlanMerge}
+	var offset int64
+	for _, f := range p.From {
+		if (len(parts) > 1) && (f.Name == parts[len(parts)-1]) {
+			return nil, fmt.Errorf("%s: given twice: %s", f.Name, ErrPlan)
+		}
+		f.Offset = offset
+		offset += f.Size
+		parts = append(parts, f.Name)
+	}
+	p.To = []*PlanPad{{planName(parts...), 0, offset}}
+	return p, nil
+}
+
+// Marshal returns the text of the plan.
+func (p *Plan) Marshal() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n%s\n", planMagic, p.Op)
+	for _, f := range p.From {
+		fmt.Fprintf(&b, "from %s %d %d\n", f.Name, f.Offset, f.Size)
+	}
+	for _, t := range p.To {
+		fmt.Fprintf(&b, "to %s %d %d\n", t.Name, t.Offset, t.Size)
+	}
+	return b.Bytes()
+}
+
+// Code returns a short code of the plan that both peers compare.
+func (p *Plan) Code() string {
+	sum := sha512.Sum512(p.Marshal())
+	code := hex.EncodeToString(sum[:8])
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
+
+// PlanFile returns the name of the file recording the plan in dir: named after
+// the original pad of a split, after the new pad of a merge.
+func (p *Plan) PlanFile(dir string) string {
+	if p.Op == PlanSplit {
+		return filepath.Join(dir, p.From[0].Name+PlanExt)
+	}
+	return filepath.Join(dir, p.To[0].Name+PlanExt)
+}
+
+// Apply applies the plan to the pads of dir having the extension ext
+// (WritePadExt or ReadPadExt): the new pads are written and synced, the plan is
+// recorded, then the original pads are destroyed with DestroyPad: the new pads
+// hold their bytes. New pads never overwrite existing files and are removed if
+// the plan fails, the original pads are then left untouched.
+func (p *Plan) Apply(dir, ext string) error {
+	var created []string
+	fail := func(err error) error {
+		for _, name := range created {
+			os.Remove(name)
+		}
+		return err
+	}
+	for _, t := range p.To {
+		name := filepath.Join(dir, t.Name+ext)
+		dst, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fail(err)
+		}
+		created = append(created, name)
+		if p.Op == PlanSplit {
+			err = copyRange(dst, filepath.Join(dir, p.From[0].Name+ext), t.Offset, t.Size)
+		} else {
+			for _, f := range p.From {
+				if err = copyRange(dst, filepath.Join(dir, f.Name+ext), 0, f.Size); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = dst.Sync()
+		}
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err := WriteFileAtomic(p.PlanFile(dir), p.Marshal(), 0600); err != nil {
+		return fail(err)
+	}
+	for _, f := range p.From {
+		if err := DestroyPad(filepath.Join(dir, f.Name+ext)); err != nil {
+			return err
+		}
+	}
+	return SyncDir(dir)
+}
+
+// copyRange appends length bytes of a file, from offset, to dst.
+func copyRange(dst io.Writer, name string, offset, length int64) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err = src.Seek(offset, 0); err != nil {
+		return err
+	}
+	if _, err = io.CopyN(dst, src, length); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
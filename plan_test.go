@@ -0,0 +1,162 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkPieces checks that the pieces of a plan cover their whole pad, in
+// order and without gap nor overlap.
+func checkPieces(t *testing.T, pieces []*PlanPad, size int64) {
+	var offset int64
+	for _, piece := range pieces {
+		if piece.Offset != offset {
+			t.Fatalf("piece %s at %d instead of %d", piece.Name, piece.Offset, offset)
+		}
+		if piece.Size <= PadOverhead+OffsetKeySize {
+			t.Fatalf("piece %s of %d bytes", piece.Name, piece.Size)
+		}
+		offset += piece.Size
+	}
+	if offset != size {
+		t.Fatalf("pieces of %d bytes out of %d", offset, size)
+	}
+}
+
+// TestSplitPlan checks that the pieces of a split sum up to the pad and that
+// the plan only depends on the name and size of the pad.
+func TestSplitPlan(t *testing.T) {
+	const name = "0123456789abcdef0123456789abcdef"
+	for _, size := range []int64{10000, 10240, 10300, 1 << 20} {
+		p, err := SplitPlan(name, size, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPieces(t, p.To, size)
+		again, err := SplitPlan(name, size, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p.Marshal(), again.Marshal()) || (p.Code() != again.Code()) {
+			t.Fatalf("%d bytes: the plan changed", size)
+		}
+		other, err := SplitPlan("fedcba9876543210fedcba9876543210", size, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.To[0].Name == p.To[0].Name {
+			t.Fatalf("%d bytes: two pads split in the same pieces", size)
+		}
+	}
+	for _, piece := range []int64{PadOverhead + OffsetKeySize, 9900, 20000} {
+		if _, err := SplitPlan(name, 10000, piece); err == nil {
+			t.Errorf("pieces of %d bytes out of 10000 accepted", piece)
+		}
+	}
+}
+
+// TestMergePlan checks that the pads of a merge sum up to the new pad and that
+// the plan does not depend on the order the pads are given in.
+func TestMergePlan(t *testing.T) {
+	names := []string{"cc", "aa", "bb"}
+	sizes := []int64{3000, 1000, 2000}
+	p, err := MergePlan(names, sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPieces(t, p.From, 6000)
+	if (p.To[0].Size != 6000) || (p.From[0].Name != "aa") || (p.From[2].Name != "cc") {
+		t.Fatalf("merge of %v in the wrong order", names)
+	}
+	reversed, err := MergePlan([]string{"bb", "cc", "aa"}, []int64{2000, 3000, 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Marshal(), reversed.Marshal()) || (p.Code() != reversed.Code()) {
+		t.Fatal("the plan depends on the order of the pads")
+	}
+	if _, err = MergePlan([]string{"aa", "aa"}, []int64{1000, 1000}); err == nil {
+		t.Error("a pad merged with itself")
+	}
+	if _, err = MergePlan([]string{"aa"}, []int64{1000}); err == nil {
+		t.Error("a single pad merged")
+	}
+}
+
+// TestPlanPeers checks that both peers applying a plan to their copy of the
+// pads get the same new pads.
+func TestPlanPeers(t *testing.T) {
+	root, err := ioutil.TempDir("", "crypt0-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	const name = "0123456789abcdef0123456789abcdef"
+	pad := random(t, 10000)
+	peers := map[string]string{WritePadExt: filepath.Join(root, "alice"), ReadPadExt: filepath.Join(root, "bob")}
+	for ext, dir := range peers {
+		if err = os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, name+ext), pad, 0600); err != nil {
+			t.Fatal(err)
+		}
+		p, err := SplitPlan(name, int64(len(pad)), 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Apply(dir, ext); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, name+ext)); !os.IsNotExist(err) {
+			t.Fatalf("%s: the split pad was kept", ext)
+		}
+		for _, piece := range p.To {
+			data, err := ioutil.ReadFile(filepath.Join(dir, piece.Name+ext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, pad[piece.Offset:piece.Offset+piece.Size]) {
+				t.Fatalf("%s: piece %s mismatch", ext, piece.Name)
+			}
+		}
+		recorded, err := ioutil.ReadFile(p.PlanFile(dir))
+		if (err != nil) || !bytes.Equal(recorded, p.Marshal()) {
+			t.Fatalf("%s: plan not recorded", ext)
+		}
+	}
+	var listings [][]string
+	for ext, dir := range peers {
+		names, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			names[i] = strings.Replace(filepath.Base(name), ext, "", 1)
+		}
+		listings = append(listings, names)
+	}
+	if strings.Join(listings[0], " ") != strings.Join(listings[1], " ") {
+		t.Fatalf("the peers got %v and %v", listings[0], listings[1])
+	}
+}
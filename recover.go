@@ -0,0 +1,184 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// UsedPadName returns the name given to a pad once exhausted.
+func UsedPadName(padName string) string {
+	if strings.HasSuffix(padName, WritePadExt) {
+		return strings.TrimSuffix(padName, WritePadExt) + UsedPadExt
+	}
+	return padName
+}
+
+// Exhausted tells if no more message can be encrypted with a pad.
+func (l *Ledger) Exhausted(padSize int64) bool {
+	return (Sliceable(padSize) - l.Next()) < PadOverhead
+}
+
+// Recover reconciles the ledger of a pad with the files after a crash. It must
+// not run while an encryption is in progress with the pad. The actions taken
+// are reported through the log function.
+//
+// Reserved slices are resolved as follows: a slice whose ciphertext is
+// complete and authenticated becomes used, a slice whose ciphertext was
+// created but never written (an empty file) is released, any other slice is
+// burned and the partial ciphertext is removed. Since the ciphertext is
+// created before the slice is reserved, a missing ciphertext may have been
+// moved away and its slice is burned too. Exhausted pads are then renamed to .x.pad.
+func Recover(padName string, log func(string)) error {
+	lockName := padName + LockExt
+	// The pad may have been renamed without its ledger
+	used := UsedPadName(padName)
+	if _, err := os.Stat(padName); os.IsNotExist(err) && (used != padName) {
+		if _, err = os.Stat(used); err == nil {
+			if _, err = os.Stat(LedgerName(padName)); err == nil {
+				l := &Ledger{Name: LedgerName(padName)}
+				if err = l.Rename(used); err != nil {
+					return err
+				}
+				log(fmt.Sprintf("`%s` renamed after `%s`", LedgerName(padName), used))
+			}
+			padName = used
+		}
+	}
+	info, err := os.Stat(padName)
+	if err != nil {
+		return err
+	}
+	l, err := OpenLedger(padName)
+	if err != nil {
+		return err
+	}
+	for _, e := range l.Pending() {
+		state := recoverEntry(padName, e)
+		if (state == StateBurned) && (e.Output != "-") {
+			if os.Remove(e.Output) == nil {
+				log(fmt.Sprintf("partial ciphertext `%s` removed", e.Output))
+			}
+		}
+		if err = l.Set(e, state); err != nil {
+			return err
+		}
+		log(fmt.Sprintf("`%s`: slice %d to %d %s", padName, e.Offset, e.End()-1, state))
+	}
+	if (used != padName) && l.Exhausted(info.Size()) {
+		if err = os.Rename(padName, used); err != nil {
+			return err
+		}
+		if err = l.Rename(used); err != nil {
+			return err
+		}
+		log(fmt.Sprintf("`%s` is exhausted, renamed to `%s`", padName, used))
+		padName = used
+	}
+	os.Remove(l.Name + ".tmp")
+	if os.Remove(lockName) == nil {
+		log(fmt.Sprintf("`%s` unlocked", padName))
+	}
+	return nil
+}
+
+// recoverEntry returns the state of a reserved slice after a crash.
+func recoverEntry(padName string, e *Entry) string {
+	if e.Output == "-" {
+		// The standard output cannot be checked
+		return StateBurned
+	}
+	if info, err := os.Stat(e.Output); (err == nil) && info.Mode().IsRegular() && (info.Size() == 0) {
+		// Nothing was written, the pad bytes never left this computer
+		return StateReleased
+	}
+	pad, err := os.Open(padName)
+	if err != nil {
+		return StateBurned
+	}
+	defer pad.Close()
+	// The slice may be used by any section of a bundle
+	for i := int64(0); ; i++ {
+		section, err := openSection(e.Output, i)
+		if err != nil {
+			return StateBurned
+		}
+		if _, err = pad.Seek(e.Offset, 0); err == nil {
+			err = Verify(pad, section)
+		}
+		section.Close()
+		if err == nil {
+			return StateUsed
+		}
+	}
+}
+
+// openSection opens a section of a bundle, the whole ciphertext being the
+// only section of a message that is not a bundle.
+func openSection(name string, i int64) (io.ReadCloser, error) {
+	output, err := openOutput(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ParseBundle(output, -1)
+	if (err == nil) && (b == nil) {
+		// Not a bundle, reading again from the beginning
+		output.Close()
+		if i > 0 {
+			return nil, io.EOF
+		}
+		return openOutput(name)
+	}
+	if (err == nil) && (i >= b.Count) {
+		err = io.EOF
+	}
+	var section io.Reader
+	if err == nil {
+		section, err = b.Section(output, i)
+	}
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+	return &outputFile{section, output.file}, nil
+}
+
+// outputFile reads a ciphertext file, decoding the ASCII armor if any.
+type outputFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (f *outputFile) Close() error {
+	return f.file.Close()
+}
+
+func openOutput(name string) (*outputFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	src := bufio.NewReaderSize(file, int(BufferSize))
+	if Armored(src) {
+		return &outputFile{NewArmorReader(src), file}, nil
+	}
+	return &outputFile{src, file}, nil
+}
@@ -0,0 +1,101 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRecover checks how the slices left reserved by a crash are resolved:
+// used when the ciphertext is complete, released when it was never written
+// and burned, with the partial ciphertext removed, otherwise.
+func TestRecover(t *testing.T) {
+	const padSize = 1 << 16
+	padName, dir := testPad(t, padSize)
+	defer os.RemoveAll(dir)
+	pad, err := ioutil.ReadFile(padName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ReadOffsetKey(bytes.NewReader(pad), padSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenLedger(padName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[*Entry]string)
+	for _, name := range []string{"complete", "partial", "empty", "missing"} {
+		output := filepath.Join(dir, name+".enc")
+		e, err := l.Reserve(4096, padSize, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext := []byte("recover " + name)
+		var out bytes.Buffer
+		opts := &Options{Size: int64(len(plaintext)), Offset: e.Offset, OffsetKey: key}
+		enc, err := NewEncrypter(bytes.NewReader(pad[e.Offset:]), &out, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = enc.Write(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		if err = enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		switch name {
+		case "complete":
+			err = ioutil.WriteFile(output, out.Bytes(), 0600)
+			expected[e] = StateUsed
+		case "partial":
+			err = ioutil.WriteFile(output, out.Bytes()[:out.Len()-1], 0600)
+			expected[e] = StateBurned
+		case "empty":
+			err = ioutil.WriteFile(output, nil, 0600)
+			expected[e] = StateReleased
+		case "missing":
+			expected[e] = StateBurned
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = Recover(padName, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = OpenLedger(padName); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range l.Entries {
+		for r, state := range expected {
+			if (r.Offset == e.Offset) && (e.State != state) {
+				t.Errorf("`%s` left its slice %s instead of %s", filepath.Base(e.Output), e.State, state)
+			}
+		}
+	}
+	for name, kept := range map[string]bool{"complete": true, "partial": false, "empty": true} {
+		if _, err = os.Stat(filepath.Join(dir, name+".enc")); (err == nil) != kept {
+			t.Errorf("`%s.enc` kept: %t", name, err == nil)
+		}
+	}
+}
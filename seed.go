@@ -0,0 +1,112 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// Seed extractor of genpads0. Every source gives SeedInput bytes, conditioned
+// with its label (its rank and kind) into a source digest:
+//
+//	D_i = SHA-512(len(label_i) || label_i || input_i)
+//
+// and the seed is made of the first SeedSize bytes of:
+//
+//	SHA-512("crypt0 genpads seed 1" || n || D_1 || ... || D_n)
+//
+// where the lengths and n are 32-bit big-endian integers. The sources are
+// never xored together, so no source can cancel another: the seed is as
+// unpredictable as the best source, crypto/rand included, as long as SHA-512
+// holds.
+const SeedInput int = 64
+const SeedSize int = 48 // AES-256 key and CTR IV
+
+const seedLabel string = "crypt0 genpads seed 1"
+
+var ErrSelfTest = errors.New("seed extractor self-test failed")
+
+// SeedLabel returns the label of the source of the given rank (0 for the
+// generator of the system) and kind ("system", "cstrng" or "prng").
+func SeedLabel(rank int, kind string) string {
+	return fmt.Sprintf("%d:%s", rank, kind)
+}
+
+func writeLength(h hash.Hash, n int) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(n))
+	h.Write(length[:])
+}
+
+// ExtractSeed returns the seed of the sources, labels and inputs have the same
+// length.
+func ExtractSeed(labels []string, inputs [][]byte) []byte {
+	seed := sha512.New()
+	seed.Write([]byte(seedLabel))
+	writeLength(seed, len(inputs))
+	for i, input := range inputs {
+		source := sha512.New()
+		writeLength(source, len(labels[i]))
+		source.Write([]byte(labels[i]))
+		source.Write(input)
+		seed.Write(source.Sum(nil))
+	}
+	return seed.Sum(nil)[:SeedSize]
+}
+
+// seedKnownAnswer is the seed of three sources whose inputs are SeedInput
+// bytes of 0x00, 0x01 and 0x02, labeled 0:system, 1:cstrng and 2:prng.
+const seedKnownAnswer string = "c6e5f141cae56e2908dc0feb7ce21c90be308d0fe2496c07e320e917c4b9dbe4" +
+	"bf74f6659c0ab3203e4529d8c9dc5dba"
+
+// SeedSelfTest checks ExtractSeed against a known answer and checks that
+// changing a single bit of any source, or its label, changes the seed.
+func SeedSelfTest() error {
+	labels := []string{SeedLabel(0, "system"), SeedLabel(1, "cstrng"), SeedLabel(2, "prng")}
+	inputs := make([][]byte, len(labels))
+	for i := range inputs {
+		inputs[i] = bytes.Repeat([]byte{byte(i)}, SeedInput)
+	}
+	seed := ExtractSeed(labels, inputs)
+	if hex.EncodeToString(seed) != seedKnownAnswer {
+		return ErrSelfTest
+	}
+	for i := range inputs {
+		inputs[i][SeedInput-1] ^= 0x80
+		changed := ExtractSeed(labels, inputs)
+		inputs[i][SeedInput-1] ^= 0x80
+		label := labels[i]
+		labels[i] = SeedLabel(i, "other")
+		relabeled := ExtractSeed(labels, inputs)
+		labels[i] = label
+		if bytes.Equal(changed, seed) || bytes.Equal(relabeled, seed) {
+			return ErrSelfTest
+		}
+	}
+	// The digests are ordered: swapping two inputs changes the seed
+	inputs[1], inputs[2] = inputs[2], inputs[1]
+	if bytes.Equal(ExtractSeed(labels, inputs), seed) {
+		return ErrSelfTest
+	}
+	return nil
+}
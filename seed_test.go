@@ -0,0 +1,110 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// seedLabels are the labels of a system source and two external sources.
+var seedLabels = []string{SeedLabel(0, "system"), SeedLabel(1, "cstrng"), SeedLabel(2, "prng")}
+
+// TestSeedKnownAnswer checks ExtractSeed against the known answer of its
+// self-test, and the known answer against the construction spelled out.
+func TestSeedKnownAnswer(t *testing.T) {
+	inputs := make([][]byte, len(seedLabels))
+	for i := range inputs {
+		inputs[i] = bytes.Repeat([]byte{byte(i)}, SeedInput)
+	}
+	if seed := hex.EncodeToString(ExtractSeed(seedLabels, inputs)); seed != seedKnownAnswer {
+		t.Fatalf("seed %s, %s expected", seed, seedKnownAnswer)
+	}
+	be32 := func(n int) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		return b
+	}
+	msg := append([]byte(seedLabel), be32(len(inputs))...)
+	for i, input := range inputs {
+		d := sha512.Sum512(append(append(be32(len(seedLabels[i])), seedLabels[i]...), input...))
+		msg = append(msg, d[:]...)
+	}
+	sum := sha512.Sum512(msg)
+	if hex.EncodeToString(sum[:SeedSize]) != seedKnownAnswer {
+		t.Fatal("the known answer is not the documented construction")
+	}
+	if err := SeedSelfTest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSeedBitFlip checks that flipping any bit of any source changes the seed.
+func TestSeedBitFlip(t *testing.T) {
+	inputs := make([][]byte, len(seedLabels))
+	for i := range inputs {
+		inputs[i] = random(t, SeedInput)
+	}
+	seed := ExtractSeed(seedLabels, inputs)
+	for i := range inputs {
+		for bit := 0; bit < 8*SeedInput; bit++ {
+			inputs[i][bit/8] ^= 1 << uint(bit%8)
+			changed := ExtractSeed(seedLabels, inputs)
+			inputs[i][bit/8] ^= 1 << uint(bit%8)
+			if bytes.Equal(changed, seed) {
+				t.Fatalf("source %d: flipping bit %d keeps the seed", i, bit)
+			}
+		}
+	}
+}
+
+// TestSeedStuckSource checks that a constant or all-zero source does not
+// cancel the others: the seed still follows each of them.
+func TestSeedStuckSource(t *testing.T) {
+	for _, stuck := range []byte{0x00, 0xff} {
+		for s := range seedLabels {
+			inputs := make([][]byte, len(seedLabels))
+			for i := range inputs {
+				inputs[i] = random(t, SeedInput)
+			}
+			inputs[s] = bytes.Repeat([]byte{stuck}, SeedInput)
+			seed := ExtractSeed(seedLabels, inputs)
+			for i := range inputs {
+				if i == s {
+					continue
+				}
+				saved := inputs[i]
+				inputs[i] = random(t, SeedInput)
+				changed := ExtractSeed(seedLabels, inputs)
+				inputs[i] = saved
+				if bytes.Equal(changed, seed) {
+					t.Fatalf("source %d stuck at %#x: the seed does not follow source %d", s, stuck, i)
+				}
+			}
+		}
+		// Every external source stuck: the seed still follows the system
+		inputs := [][]byte{random(t, SeedInput), bytes.Repeat([]byte{stuck}, SeedInput), bytes.Repeat([]byte{stuck}, SeedInput)}
+		seed := ExtractSeed(seedLabels, inputs)
+		inputs[0] = random(t, SeedInput)
+		if bytes.Equal(ExtractSeed(seedLabels, inputs), seed) {
+			t.Fatalf("external sources stuck at %#x: the seed does not follow the system source", stuck)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package serve0
+
+import (
+	"html/template"
+)
+
+// Page is the only page of the interface, self-contained: no script, no
+// external resource.
+var Page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>crypt0</title>
+<style>
+body { font-family: sans-serif; max-width: 52em; margin: 2em auto; padding: 0 1em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; margin-top: 2em; }
+form { border: 1px solid #bbb; border-radius: 6px; padding: 1em; }
+input[type=file] { display: block; box-sizing: border-box; width: 100%; margin: 1em 0; padding: 2.5em 1em;
+	border: 2px dashed #888; border-radius: 6px; background: #f6f6f6; }
+pre.message { background: #fee; border: 1px solid #c66; padding: 1em; white-space: pre-wrap; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.5em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+tr.low { background: #fec; }
+</style>
+</head>
+<body>
+<h1>crypt0</h1>
+{{if .Message}}<pre class="message">{{.Message}}</pre>{{end}}
+
+<h2>Encrypt a file</h2>
+{{if .Senders}}
+<form method="post" action="encrypt" enctype="multipart/form-data">
+<label>Peer
+<select name="peer">
+{{range .Senders}}<option value="{{.Peer}}">{{.Peer}} (up to {{.Largest}} bytes)</option>
+{{end}}</select></label>
+<label><input type="checkbox" name="armor"{{if .Armor}} checked{{end}}> ASCII armor (for mail and chat)</label>
+<input type="file" name="file" required title="Drop the file here">
+<button type="submit">Encrypt and download</button>
+</form>
+{{else}}
+<p>No peer has a write pad left.</p>
+{{end}}
+
+<h2>Decrypt a file</h2>
+<form method="post" action="decrypt" enctype="multipart/form-data">
+<input type="file" name="file" required title="Drop the .enc or .enc.asc file here">
+<button type="submit">Decrypt and download</button>
+</form>
+
+<h2>Pads</h2>
+<table>
+<tr><th>Peer</th><th>Direction</th><th>Unused</th><th>In use</th><th>Exhausted</th><th>Pending</th><th>Bytes</th><th>Largest message</th></tr>
+{{range .Stocks}}<tr{{if .Low}} class="low" title="{{range .Low}}{{.}}. {{end}}"{{end}}><td>{{.Peer}}</td><td>{{if eq .Direction "w"}}send{{else}}receive{{end}}</td><td>{{.Unused}}</td><td>{{.InUse}}</td><td>{{.Exhausted}}</td><td>{{.Pending}}</td><td>{{.Bytes}}</td><td>{{.Largest}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
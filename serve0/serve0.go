@@ -0,0 +1,365 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+// Package serve0 is the `crypt0 serve` command: a web interface for the
+// encryption and the decryption, served on localhost only.
+package serve0
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/piotrcki/crypt0"
+	"github.com/piotrcki/crypt0/cli"
+)
+
+// MaxField is the size limit of the form fields other than the files.
+const MaxField int64 = 1024
+
+var Args []string // the command line, Args[0] is the command name
+var Port int = 0  // random by default
+var Token string = ""
+var Address string = "" // the address of the server, checked in the Host header
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n\n")
+	fmt.Fprintf(os.Stderr, "%s [--port port]\n\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "--port: the port to listen to on 127.0.0.1, a random one by default\n\n")
+	fmt.Fprintf(os.Stderr, "The address to open, secret token included, is printed on start.\n\n")
+	fmt.Fprintf(os.Stderr, "Return values:\n\n")
+	fmt.Fprintf(os.Stderr, "9: error\n")
+	cli.Exit(cli.ExitError)
+}
+
+func ParseArgs() {
+	for i := 1; i < len(Args); i++ {
+		switch {
+		case (Args[i] == "--port") && (i+1 < len(Args)):
+			i++
+			port, err := strconv.Atoi(Args[i])
+			if (err != nil) || (port < 0) || (port > 65535) {
+				Usage()
+			}
+			Port = port
+		default:
+			Usage()
+		}
+	}
+}
+
+// NewToken returns the secret part of the address: without it, the other
+// users and the web pages open in the browser cannot use the server.
+func NewToken() string {
+	buff := make([]byte, 16)
+	_, err := rand.Read(buff)
+	cli.FatalCheck(err)
+	return hex.EncodeToString(buff)
+}
+
+// Main runs the command with its arguments.
+func Main(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	ParseArgs()
+	Token = NewToken()
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", Port))
+	cli.FatalCheck(err)
+	Address = listener.Addr().String()
+	fmt.Printf("%s: open http://%s/%s/ in a browser, Ctrl-C to stop.\n", cli.Name, Address, Token)
+	server := &http.Server{Handler: http.HandlerFunc(Handle), ReadHeaderTimeout: 10 * time.Second}
+	cli.FatalCheck(server.Serve(listener))
+}
+
+// Handle checks the address and the token of a request and dispatches it.
+// Nothing sent or received is logged.
+func Handle(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	if r.Host != Address {
+		// DNS rebinding
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if (len(parts) != 2) || (subtle.ConstantTimeCompare([]byte(parts[0]), []byte(Token)) != 1) {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case (parts[1] == "") && (r.Method == http.MethodGet):
+		Home(w, "")
+	case (parts[1] == "encrypt") && (r.Method == http.MethodPost):
+		Encrypt(w, r)
+	case (parts[1] == "decrypt") && (r.Method == http.MethodPost):
+		Decrypt(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Stocks returns the pad inventory of the peers of CRYPT0_HOME, the index is
+// refreshed first.
+func Stocks() ([]*crypt0.Stock, error) {
+	index, err := crypt0.OpenIndex(crypt0.IndexName())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(crypt0.PeersDir()); os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err = index.Refresh(crypt0.PeersDir(), func(string) {}); err != nil {
+		return nil, err
+	}
+	if err = index.Save(); err != nil {
+		return nil, err
+	}
+	records, err := index.Within(crypt0.PeersDir())
+	if err != nil {
+		return nil, err
+	}
+	peers, err := crypt0.ListPeers(crypt0.PeersDir())
+	if err != nil {
+		return nil, err
+	}
+	stocks := crypt0.Inventory(records, peers)
+	for _, s := range stocks {
+		s.Check(cli.Conf.Thresholds)
+	}
+	return stocks, nil
+}
+
+// Senders returns the peers having a write pad left.
+func Senders(stocks []*crypt0.Stock) []*crypt0.Stock {
+	var ret []*crypt0.Stock
+	for _, s := range stocks {
+		if (s.Direction == crypt0.DirectionWrite) && (s.Largest > 0) {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// Sender tells if a peer has a write pad left.
+func Sender(peer string) bool {
+	stocks, err := Stocks()
+	if err != nil {
+		return false
+	}
+	for _, s := range Senders(stocks) {
+		if s.Peer == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// Home shows the forms, the pad inventory and a message.
+func Home(w http.ResponseWriter, message string) {
+	stocks, err := Stocks()
+	if err != nil {
+		message = strings.TrimSpace(message + "\n" + err.Error())
+	}
+	data := struct {
+		Message string
+		Stocks  []*crypt0.Stock
+		Senders []*crypt0.Stock
+		Armor   bool
+	}{message, stocks, Senders(stocks), cli.Conf.Armor}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := Page.Execute(w, data); err != nil {
+		cli.Warning(err.Error())
+	}
+}
+
+// Fail shows the messages of a failed command.
+func Fail(w http.ResponseWriter, action, messages string) {
+	cli.Warning(fmt.Sprintf("%s failed", action))
+	Home(w, fmt.Sprintf("%s failed:\n%s", action, messages))
+}
+
+// readField reads a small form field.
+func readField(part *multipart.Part) (string, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(part, MaxField+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > MaxField {
+		return "", fmt.Errorf("field `%s` too long", part.FormName())
+	}
+	return string(data), nil
+}
+
+// Encrypt streams the uploaded file to `crypt0 encrypt --peer peer -`, which
+// selects the pad and buffers the plaintext encrypted with a throw-away key,
+// and sends the ciphertext back as a download. The plaintext never reaches
+// the disk in clear.
+func Encrypt(w http.ResponseWriter, r *http.Request) {
+	form, err := r.MultipartReader()
+	if err != nil {
+		Fail(w, "Encryption", err.Error())
+		return
+	}
+	peer := ""
+	armor := "--no-armor"
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Fail(w, "Encryption", err.Error())
+			return
+		}
+		switch part.FormName() {
+		case "peer":
+			if peer, err = readField(part); err != nil {
+				Fail(w, "Encryption", err.Error())
+				return
+			}
+		case "armor":
+			armor = "--armor"
+		case "file":
+			if !Sender(peer) {
+				Fail(w, "Encryption", fmt.Sprintf("no write pad left for `%s`", peer))
+				return
+			}
+			name := filepath.Base(part.FileName()) + crypt0.CiphertextExt
+			if armor == "--armor" {
+				name += crypt0.ArmorExt
+			}
+			dst := &Download{w: w, name: name}
+			messages, status := cli.Spawn(part, dst, "encrypt", armor, "--peer", peer, "-")
+			Finish(w, dst, "Encryption", messages, status)
+			return
+		}
+	}
+	Fail(w, "Encryption", "no file")
+}
+
+// Decrypt streams the uploaded ciphertext to `crypt0 decrypt -o - - peers`,
+// which authenticates it before releasing any plaintext, and sends the
+// plaintext back as a download. An encrypted directory is sent as a tar
+// archive.
+func Decrypt(w http.ResponseWriter, r *http.Request) {
+	form, err := r.MultipartReader()
+	if err != nil {
+		Fail(w, "Decryption", err.Error())
+		return
+	}
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Fail(w, "Decryption", err.Error())
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(part.FileName()), crypt0.ArmorExt)
+		if strings.HasSuffix(name, crypt0.CiphertextExt) && (name != crypt0.CiphertextExt) {
+			name = strings.TrimSuffix(name, crypt0.CiphertextExt)
+		} else {
+			name += ".out"
+		}
+		dst := &Download{w: w, name: name}
+		messages, status := cli.Spawn(part, dst, "decrypt", "-o", "-", "-", crypt0.PeersDir())
+		Finish(w, dst, "Decryption", messages, status)
+		return
+	}
+	Fail(w, "Decryption", "no file")
+}
+
+// Finish completes a download, or shows why the command failed. The headers
+// of a download are only sent with its first bytes, so that a failure can be
+// shown as long as nothing was sent.
+func Finish(w http.ResponseWriter, dst *Download, action, messages string, status int) {
+	if status != cli.ExitSuccess {
+		if !dst.started {
+			Fail(w, action, messages)
+		} else {
+			cli.Warning(fmt.Sprintf("%s failed during the download", action))
+		}
+		return
+	}
+	if err := dst.Close(); err != nil {
+		cli.Warning(err.Error())
+	}
+	cli.Report(fmt.Sprintf("%s succeeded", action))
+}
+
+// Download is a writer sending a file to the browser, the headers are sent
+// with the first block. A tar archive gets the .tar extension.
+type Download struct {
+	w       http.ResponseWriter
+	name    string
+	buff    []byte
+	started bool
+}
+
+const tarBlock int = 512
+
+func (d *Download) start() error {
+	d.started = true
+	if (len(d.buff) >= tarBlock) && (string(d.buff[257:262]) == "ustar") {
+		d.name += ".tar"
+	}
+	h := d.w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.name))
+	d.w.WriteHeader(http.StatusOK)
+	_, err := d.w.Write(d.buff)
+	d.buff = nil
+	return err
+}
+
+func (d *Download) Write(p []byte) (int, error) {
+	if d.started {
+		return d.w.Write(p)
+	}
+	d.buff = append(d.buff, p...)
+	if len(d.buff) >= tarBlock {
+		if err := d.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close sends what is left, an empty download included.
+func (d *Download) Close() error {
+	if d.started {
+		return nil
+	}
+	return d.start()
+}
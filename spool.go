@@ -0,0 +1,105 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Spool buffers a stream of unknown length in a temporary file so that its
+// size is known before it is read back. The data is encrypted with AES-256 in
+// CTR mode under an ephemeral key that never leaves the memory, so what
+// reaches the disk is useless once the process is gone.
+type Spool struct {
+	file   *os.File
+	block  cipher.Block
+	iv     []byte
+	reader io.Reader
+	size   int64
+}
+
+// NewSpool reads src until EOF. It returns ErrPadTooShort if more than limit
+// bytes are read, a negative limit means no limit.
+func NewSpool(src io.Reader, limit int64) (*Spool, error) {
+	key := make([]byte, AesKeySize)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile("", "crypt0-spool-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{file: file, block: block, iv: iv}
+	// Unlinking now is enough on Unix, Close retries for other systems
+	os.Remove(file.Name())
+	if limit >= 0 {
+		src = io.LimitReader(src, limit+1)
+	}
+	w := &cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: file}
+	if s.size, err = io.Copy(w, src); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if (limit >= 0) && (s.size > limit) {
+		s.Close()
+		return nil, ErrPadTooShort
+	}
+	if err = s.Rewind(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rewind restarts reading from the beginning of the spooled data.
+func (s *Spool) Rewind() error {
+	if _, err := s.file.Seek(0, 0); err != nil {
+		return err
+	}
+	s.reader = &cipher.StreamReader{S: cipher.NewCTR(s.block, s.iv), R: s.file}
+	return nil
+}
+
+// Size returns the number of bytes read from the source.
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// Read reads back the spooled data.
+func (s *Spool) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Close closes and removes the temporary file.
+func (s *Spool) Close() error {
+	err := s.file.Close()
+	os.Remove(s.file.Name())
+	return err
+}
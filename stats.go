@@ -0,0 +1,186 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"compress/flate"
+	"math"
+	"math/bits"
+)
+
+// StatAlpha is the significance level of the statistical tests: a good pad
+// fails a given test with this probability.
+const StatAlpha float64 = 0.0001
+
+// StatResult is the outcome of a statistical test.
+type StatResult struct {
+	Test  string
+	Value float64 // the statistic, see the test
+	P     float64 // the p-value, NaN when the test has none
+	Pass  bool
+}
+
+// StatTest runs a battery of statistical tests over the bytes written to it:
+//
+// * monobit: the proportion of ones (SP 800-22 frequency test);
+//
+// * runs: the number of runs of identical bits (SP 800-22 runs test);
+//
+// * chi-square: the distribution of the byte values, 255 degrees of freedom;
+//
+// * serial correlation: the correlation coefficient of consecutive bytes;
+//
+// * compression: the compressed size ratio estimated with deflate, that no
+// random data can lower.
+//
+// These tests catch a broken generator, not a weak one: the output of any
+// decent pseudo-random generator passes them.
+type StatTest struct {
+	n       int64 // bytes
+	ones    int64
+	runs    int64
+	counts  [256]int64
+	first   byte
+	last    byte
+	sum     float64
+	sum2    float64
+	sumXY   float64
+	deflate *flate.Writer
+	packed  countWriter
+}
+
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
+
+// NewStatTest returns an empty test battery.
+func NewStatTest() *StatTest {
+	t := &StatTest{}
+	t.deflate, _ = flate.NewWriter(&t.packed, flate.BestSpeed)
+	return t
+}
+
+func (t *StatTest) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if t.n == 0 {
+			t.first = b
+			t.runs = 1
+		} else {
+			t.runs += int64((t.last ^ (b >> 7)) & 1) // the bits are read from the MSB
+			t.sumXY += float64(t.last) * float64(b)
+		}
+		t.runs += int64(bits.OnesCount8((b ^ (b >> 1)) & 0x7f))
+		t.ones += int64(bits.OnesCount8(b))
+		t.counts[b]++
+		t.sum += float64(b)
+		t.sum2 += float64(b) * float64(b)
+		t.last = b
+		t.n++
+	}
+	return t.deflate.Write(p)
+}
+
+// Results returns the outcome of every test.
+func (t *StatTest) Results() []*StatResult {
+	var ret []*StatResult
+	add := func(test string, value, p float64) {
+		ret = append(ret, &StatResult{test, value, p, p >= StatAlpha})
+	}
+	n := float64(t.n * 8)
+	if t.n == 0 {
+		return nil
+	}
+	// Monobit: the value is the proportion of ones
+	pi := float64(t.ones) / n
+	add("monobit", pi, math.Erfc(math.Abs(2*float64(t.ones)-n)/math.Sqrt(n)/math.Sqrt2))
+	// Runs: the value is the number of runs, only tested if monobit passes
+	p := 0.0
+	if math.Abs(pi-0.5) < 2/math.Sqrt(n) {
+		p = math.Erfc(math.Abs(float64(t.runs)-2*n*pi*(1-pi)) / (2 * math.Sqrt(2*n) * pi * (1 - pi)))
+	}
+	add("runs", float64(t.runs), p)
+	// Chi-square: the value is the statistic
+	expected := float64(t.n) / 256
+	chi2 := 0.0
+	for _, c := range t.counts {
+		chi2 += (float64(c) - expected) * (float64(c) - expected) / expected
+	}
+	add("chi-square", chi2, gammaQ(255.0/2, chi2/2))
+	// Serial correlation: the value is the coefficient, the last byte is
+	// followed by the first one like in ent
+	m := float64(t.n)
+	sumXY := t.sumXY + float64(t.last)*float64(t.first)
+	r := (m*sumXY - t.sum*t.sum) / (m*t.sum2 - t.sum*t.sum)
+	if math.IsNaN(r) {
+		r = 1 // constant data
+	}
+	add("serial correlation", r, math.Erfc(math.Abs(r)*math.Sqrt(m)/math.Sqrt2))
+	// Compression: the value is the compressed size ratio
+	t.deflate.Close()
+	ratio := float64(t.packed) / m
+	ret = append(ret, &StatResult{"compression", ratio, math.NaN(), ratio >= 1})
+	return ret
+}
+
+// gammaQ returns the regularized upper incomplete gamma function Q(a, x).
+func gammaQ(a, x float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	lg, _ := math.Lgamma(a)
+	prefix := math.Exp(a*math.Log(x) - x - lg)
+	if x < a+1 {
+		// Series of P(a, x)
+		sum, term := 1/a, 1/a
+		for k := 1.0; k < 1000; k++ {
+			term *= x / (a + k)
+			sum += term
+			if term < sum*1e-15 {
+				break
+			}
+		}
+		return 1 - prefix*sum
+	}
+	// Continued fraction of Q(a, x), modified Lentz's method
+	tiny := 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1.0; i < 1000; i++ {
+		an := -i * (i - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return prefix * h
+}
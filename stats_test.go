@@ -0,0 +1,75 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// statResults runs the statistical tests on data and returns the results by
+// test name.
+func statResults(data []byte) map[string]*StatResult {
+	st := NewStatTest()
+	st.Write(data)
+	ret := make(map[string]*StatResult)
+	for _, r := range st.Results() {
+		ret[r.Test] = r
+	}
+	return ret
+}
+
+// TestStatsBroken checks that constant and heavily biased data fail the
+// monobit and chi-square tests.
+func TestStatsBroken(t *testing.T) {
+	biased := random(t, 1<<16)
+	noise := random(t, len(biased))
+	for i := range biased {
+		biased[i] |= noise[i] // three bits out of four are ones
+	}
+	for name, data := range map[string][]byte{
+		"zeros":  make([]byte, 1<<16),
+		"ones":   bytes.Repeat([]byte{0xff}, 1<<16),
+		"biased": biased,
+	} {
+		results := statResults(data)
+		for _, test := range []string{"monobit", "chi-square"} {
+			if results[test].Pass {
+				t.Errorf("%s: the %s test passes (%g, p = %g)", name, test, results[test].Value, results[test].P)
+			}
+		}
+	}
+}
+
+// TestStatsRandom checks that every test passes on random data. The data is
+// the AES-CTR stream of a fixed key, so that the test does not fail once in a
+// while as it would on crypto/rand output.
+func TestStatsRandom(t *testing.T) {
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1<<20)
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(data, data)
+	for test, r := range statResults(data) {
+		if !r.Pass {
+			t.Errorf("the %s test fails (%g, p = %g)", test, r.Value, r.P)
+		}
+	}
+}
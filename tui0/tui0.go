@@ -0,0 +1,413 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+// Package tui0 is the `crypt0 tui` command: a full-screen terminal view of the
+// peers of $CRYPT0_HOME/peers and of their pads, with the actions that would
+// otherwise be done by hand in the peer directories.
+package tui0
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/piotrcki/crypt0"
+	"github.com/piotrcki/crypt0/cli"
+	"github.com/piotrcki/crypt0/gui0"
+)
+
+// Recent is the number of recently used pads shown.
+const Recent int = 5
+
+var Args []string // the command line, Args[0] is the command name
+var Index *crypt0.Index
+var Records []*crypt0.PadRecord // the pads of the peer directories
+var Peers []string
+var Stocks []*crypt0.Stock
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n\n")
+	fmt.Fprintf(os.Stderr, "%s\n\n", cli.Name)
+	fmt.Fprintf(os.Stderr, "Manage the peers of $CRYPT0_HOME/peers and their pads, and encrypt or decrypt files.\n\n")
+	fmt.Fprintf(os.Stderr, "Return values:\n\n")
+	fmt.Fprintf(os.Stderr, "0: success\n")
+	fmt.Fprintf(os.Stderr, "9: error\n")
+	cli.Exit(cli.ExitError)
+}
+
+// Load refreshes the pad index from the peer directories and sums up the
+// stocks of every peer.
+func Load() {
+	var err error
+	dir := crypt0.PeersDir()
+	cli.FatalCheck(os.MkdirAll(dir, 0700))
+	Index, err = crypt0.OpenIndex(crypt0.IndexName())
+	cli.FatalCheck(err)
+	cli.FatalCheck(Index.Refresh(dir, func(string) {}))
+	cli.FatalCheck(Index.Save())
+	Records, err = Index.Within(dir)
+	cli.FatalCheck(err)
+	Peers, err = crypt0.ListPeers(dir)
+	cli.FatalCheck(err)
+	Stocks = crypt0.Inventory(Records, Peers)
+}
+
+// Stock returns the stock of a peer in a direction.
+func Stock(peer, direction string) *crypt0.Stock {
+	for _, s := range Stocks {
+		if (s.Peer == peer) && (s.Direction == direction) {
+			return s
+		}
+	}
+	return &crypt0.Stock{Peer: peer, Direction: direction}
+}
+
+// Clear clears the terminal, nothing is written when the output is not one.
+func Clear() {
+	if info, err := os.Stdout.Stat(); (err == nil) && (info.Mode()&os.ModeCharDevice != 0) {
+		fmt.Fprintf(gui0.Output, "\033[H\033[2J")
+	}
+}
+
+// Draw shows the peers, their stocks and the recently used pads.
+func Draw() {
+	Clear()
+	fmt.Fprintf(gui0.Output, "crypt0 - %s\n\n", crypt0.PeersDir())
+	if len(Peers) == 0 {
+		fmt.Fprintf(gui0.Output, "No peer yet, import the pads made by genpads0 with [i].\n")
+	} else {
+		w := tabwriter.NewWriter(gui0.Output, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "PEER\tSEND PADS\tSEND BYTES\tLARGEST\tRECEIVE PADS\tRECEIVE BYTES\tSTATUS\n")
+		for _, peer := range Peers {
+			send, receive := Stock(peer, crypt0.DirectionWrite), Stock(peer, crypt0.DirectionRead)
+			status := "ok"
+			if send.Check(cli.Conf.Thresholds) {
+				status = "low: " + strings.Join(send.Low, ", ")
+			}
+			if send.Pending+receive.Pending > 0 {
+				status += ", pending (see `pads recover`)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", peer, send.Unused+send.InUse, send.Bytes, send.Largest,
+				receive.Unused+receive.InUse, receive.Bytes, status)
+		}
+		w.Flush()
+	}
+	var recent []*crypt0.PadRecord
+	for _, r := range Records {
+		if !r.LastUsed.IsZero() {
+			recent = append(recent, r)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i].LastUsed.After(recent[j].LastUsed) })
+	if len(recent) > Recent {
+		recent = recent[:Recent]
+	}
+	if len(recent) > 0 {
+		fmt.Fprintf(gui0.Output, "\nRecently used pads:\n\n")
+		w := tabwriter.NewWriter(gui0.Output, 0, 8, 2, ' ', 0)
+		for _, r := range recent {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%d bytes left\n", r.LastUsed.Local().Format("2006-01-02 15:04"),
+				r.Peer, r.Direction, filepath.Base(r.Path), r.Remaining)
+		}
+		w.Flush()
+	}
+	fmt.Fprintf(gui0.Output, "\n[e] encrypt  [d] decrypt  [i] import pads  [p] list pads  [b] burn pads  [r] retire a peer  [q] quit\n")
+}
+
+// ChoosePeer asks for one of the peers.
+func ChoosePeer(caption string) (string, error) {
+	if len(Peers) == 0 {
+		return "", fmt.Errorf("no peer in %s", crypt0.PeersDir())
+	}
+	i, err := gui0.Choose(caption, "Peer", Peers)
+	if err != nil {
+		return "", err
+	}
+	return Peers[i], nil
+}
+
+// PeerPads returns the records of the pads of a peer, oldest first.
+func PeerPads(peer string) []*crypt0.PadRecord {
+	var ret []*crypt0.PadRecord
+	for _, r := range Records {
+		if r.Peer == peer {
+			ret = append(ret, r)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Created.Before(ret[j].Created) })
+	return ret
+}
+
+// Describe returns a line describing a pad.
+func Describe(r *crypt0.PadRecord) string {
+	return fmt.Sprintf("%s  %s  %s  %d/%d bytes left", filepath.Base(r.Path), r.Direction, r.State, r.Remaining, r.Size)
+}
+
+func Encrypt() error {
+	var senders []string
+	for _, peer := range Peers {
+		if Stock(peer, crypt0.DirectionWrite).Largest > 0 {
+			senders = append(senders, peer)
+		}
+	}
+	if len(senders) == 0 {
+		return fmt.Errorf("no peer has a write pad left")
+	}
+	i, err := gui0.Choose("Encrypt", "Peer to encrypt the file for", senders)
+	if err != nil {
+		return err
+	}
+	input, err := gui0.AskPath("Encrypt", "File or directory to encrypt")
+	if err != nil {
+		return err
+	}
+	out, status := cli.Spawn(nil, nil, "encrypt", "--peer", senders[i], "--", input)
+	Result("Encryption", out, status)
+	return nil
+}
+
+func Decrypt() error {
+	input, err := gui0.AskPath("Decrypt", "Ciphertext to decrypt (a .enc or .enc.asc file)")
+	if err != nil {
+		return err
+	}
+	out, status := cli.Spawn(nil, nil, "decrypt", "--", input, crypt0.PeersDir())
+	Result("Decryption", out, status)
+	return nil
+}
+
+// Import installs the pads of a bundle (see `pads export`), or copies the pads
+// of a peer directory made by genpads0, such as alice.pads/bob on the computer
+// of alice, into the directory of the peer. The pads are checked against their
+// manifest first, if any.
+func Import() error {
+	src, err := gui0.AskPath("Import pads", "Bundle or directory of the pads made by genpads0 (like alice.pads/bob)")
+	if err != nil {
+		return err
+	}
+	src = filepath.Clean(src)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		peer, err := gui0.Prompt("Peer name (empty for the peer of the bundle): ")
+		if err != nil {
+			return err
+		}
+		expected, err := gui0.Prompt("Code printed by the peer (empty to compare it later): ")
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dir, m, imported, err := crypt0.ImportPadBundle(f, crypt0.PeersDir(), peer, expected)
+		if err != nil {
+			return err
+		}
+		if err = IndexFresh(imported); err != nil {
+			return err
+		}
+		code := crypt0.ManifestCode(m.Entries)
+		if m.KeyPad != "" {
+			gui0.Message("Import pads", fmt.Sprintf("%d pads of `%s` imported, manifest authenticated with the pad %s, code %s.",
+				len(imported), filepath.Base(dir), m.KeyPad, code))
+			return nil
+		}
+		if expected != "" {
+			gui0.Message("Import pads", fmt.Sprintf("%d pads of `%s` imported, code %s, the code of your peer.",
+				len(imported), filepath.Base(dir), code))
+			return nil
+		}
+		gui0.Message("Import pads", fmt.Sprintf("%d pads of `%s` imported, check that your peer has the code %s.",
+			len(imported), filepath.Base(dir), code))
+		return nil
+	}
+	peer := filepath.Base(src)
+	answer, err := gui0.Prompt(fmt.Sprintf("Peer name [%s]: ", peer))
+	if err != nil {
+		return err
+	}
+	if answer != "" {
+		peer = answer
+	}
+	if (peer == ".") || (peer == "..") || strings.ContainsAny(peer, `/\`) {
+		return fmt.Errorf("%s: invalid peer name", peer)
+	}
+	verified := "no manifest to check the pads against"
+	if _, err = os.Stat(filepath.Join(src, crypt0.ManifestName)); err == nil {
+		var problems []string
+		m, err := crypt0.VerifyManifest(src, crypt0.PeersDir(), func(msg string) { problems = append(problems, msg) })
+		if err != nil {
+			return fmt.Errorf("%s\n%s", strings.Join(problems, "\n"), err)
+		}
+		verified = fmt.Sprintf("the pads match their manifest, check that %s has the code %s", peer, crypt0.ManifestCode(m.Entries))
+		if m.KeyPad != "" {
+			verified = fmt.Sprintf("the pads match their manifest authenticated with the pad %s", m.KeyPad)
+		}
+	}
+	imported, err := crypt0.ImportPads(src, filepath.Join(crypt0.PeersDir(), peer))
+	if err != nil {
+		return fmt.Errorf("%s (%d pads imported)", err, len(imported))
+	}
+	if len(imported) == 0 {
+		return fmt.Errorf("no pad in %s", src)
+	}
+	if err = IndexFresh(imported); err != nil {
+		return err
+	}
+	gui0.Message("Import pads", fmt.Sprintf("%d pads of `%s` imported (%s), %s can now be wiped.", len(imported), peer, verified, src))
+	return nil
+}
+
+// IndexFresh indexes the pads just imported as unused.
+func IndexFresh(pads []string) error {
+	if err := Index.Fresh(pads...); err != nil {
+		return err
+	}
+	return Index.Save()
+}
+
+func List() error {
+	peer, err := ChoosePeer("List pads")
+	if err != nil {
+		return err
+	}
+	var text bytes.Buffer
+	w := tabwriter.NewWriter(&text, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "PAD\tDIRECTION\tSTATE\tREMAINING\tSIZE\tCREATED\n")
+	for _, r := range PeerPads(peer) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", filepath.Base(r.Path), r.Direction, r.State, r.Remaining,
+			r.Size, r.Created.Local().Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+	gui0.Message("Pads of "+peer, text.String())
+	return nil
+}
+
+// Burn destroys one pad or all the exhausted pads of a peer.
+func Burn() error {
+	peer, err := ChoosePeer("Burn pads")
+	if err != nil {
+		return err
+	}
+	pads := PeerPads(peer)
+	var exhausted []string
+	items := []string{"all the exhausted pads"}
+	for _, r := range pads {
+		if r.State == crypt0.PadExhausted {
+			exhausted = append(exhausted, r.Path)
+		}
+		items = append(items, Describe(r))
+	}
+	i, err := gui0.Choose("Burn pads", "Pads of "+peer+" to destroy", items)
+	if err != nil {
+		return err
+	}
+	targets := exhausted
+	if i > 0 {
+		targets = []string{pads[i-1].Path}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("`%s` has no exhausted pad", peer)
+	}
+	if !gui0.Confirm("Burn pads", fmt.Sprintf("Destroy %d pads of %s for good?", len(targets), peer)) {
+		return gui0.ErrCanceled
+	}
+	for _, padName := range targets {
+		if err = crypt0.BurnPad(padName); err != nil {
+			return fmt.Errorf("%s: %s", padName, err)
+		}
+	}
+	gui0.Message("Burn pads", fmt.Sprintf("%d pads destroyed.", len(targets)))
+	return nil
+}
+
+// Retire destroys every pad of a peer and removes its directory.
+func Retire() error {
+	peer, err := ChoosePeer("Retire a peer")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(crypt0.PeersDir(), peer)
+	pads, err := crypt0.FindPads(dir)
+	if err != nil {
+		return err
+	}
+	answer, err := gui0.Prompt(fmt.Sprintf("\nThe %d pads of %s will be destroyed: no message can be exchanged with %s anymore.\n"+
+		"Type the name of the peer to confirm: ", len(pads), peer, peer))
+	if (err != nil) || (answer != peer) {
+		return gui0.ErrCanceled
+	}
+	for _, padName := range pads {
+		if err = crypt0.BurnPad(padName); err != nil {
+			return fmt.Errorf("%s: %s", padName, err)
+		}
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		return err
+	}
+	gui0.Message("Retire a peer", fmt.Sprintf("`%s` retired, %d pads destroyed.", peer, len(pads)))
+	return nil
+}
+
+// Result shows the messages of a command.
+func Result(action, out string, status int) {
+	if status == cli.ExitSuccess {
+		gui0.Message(action+" succeeded", out)
+	} else {
+		gui0.Message(action+" failed", out)
+	}
+}
+
+// Main runs the command with its arguments.
+func Main(args []string) {
+	Args = append([]string{cli.Name}, args...)
+	if len(Args) != 1 {
+		Usage()
+	}
+	actions := map[string]func() error{
+		"e": Encrypt, "d": Decrypt, "i": Import, "p": List, "b": Burn, "r": Retire,
+	}
+	for {
+		Load()
+		Draw()
+		answer, err := gui0.Prompt("> ")
+		if err != nil {
+			break
+		}
+		answer = strings.ToLower(answer)
+		if answer == "q" {
+			break
+		}
+		action := actions[answer]
+		if action == nil {
+			continue
+		}
+		if err = action(); (err != nil) && (err != gui0.ErrCanceled) {
+			gui0.Message("Error", err.Error())
+		}
+	}
+	fmt.Fprintf(gui0.Output, "\n")
+	cli.Exit(cli.ExitSuccess)
+}
@@ -0,0 +1,107 @@
+//   Copyright (C) 2015 Piotr Chmielnicki
+//
+//   This program is free software; you can redistribute it and/or modify
+//   it under the terms of the GNU General Public License as published by
+//   the Free Software Foundation; either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU General Public License for more details.
+//
+//   You should have received a copy of the GNU General Public License
+//   along with this program; if not, write to the Free Software Foundation,
+//   Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301  USA
+
+package crypt0
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Wipe policies, see DefaultWipe.
+const WipeNo string = "no"
+const WipeYes string = "yes"
+const WipeDryRun string = "dry-run"
+
+// DefaultWipe returns the wipe policy set by $CRYPT0_WIPE, WipeNo when unset
+// or unknown.
+func DefaultWipe() string {
+	switch strings.ToLower(os.Getenv("CRYPT0_WIPE")) {
+	case "1", "yes", "true":
+		return WipeYes
+	case WipeDryRun:
+		return WipeDryRun
+	}
+	return WipeNo
+}
+
+// WipeRange overwrites a range of a file with random data and syncs it.
+// Like any overwriting, it gives no guarantee on copy-on-write file systems
+// or flash storage with wear leveling.
+func WipeRange(name string, r Range) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err = wipe(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func wipe(f *os.File, r Range) error {
+	if _, err := f.Seek(r.Offset, 0); err != nil {
+		return err
+	}
+	buff := make([]byte, BufferSize)
+	for left := r.Length; left > 0; {
+		todo := BufferSize
+		if left < todo {
+			todo = left
+		}
+		if _, err := rand.Read(buff[:todo]); err != nil {
+			return err
+		}
+		if _, err := f.Write(buff[:todo]); err != nil {
+			return err
+		}
+		left -= todo
+	}
+	return f.Sync()
+}
+
+// DestroyPad overwrites a whole pad with random data, syncs it, truncates it
+// and unlinks it with its ledger.
+func DestroyPad(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = wipe(f, Range{0, info.Size()})
+	}
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(name); err != nil {
+		return err
+	}
+	os.Remove(LedgerName(name))
+	return SyncDir(filepath.Dir(name))
+}